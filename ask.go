@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"cloud.google.com/go/vertexai/genai"
+)
+
+// askInstruction frames the answers of the ask command.
+const askInstruction = `You answer questions about a set of interview or meeting transcripts, given between <transcript> tags.
+
+1. Answer only from the transcripts; if they do not cover the question, say so instead of guessing
+2. Support every statement with a citation of the form [file, speaker, timestamp]; leave out the timestamp when the transcript has none, and use "unknown speaker" when the speaker is not identified
+3. Prefer short verbatim quotes to paraphrases, and mention when participants disagree
+4. Answer in the language of the question, as markdown`
+
+// exchange is a question and its answer.
+type exchange struct {
+	Question string
+	Answer   string
+}
+
+// asker answers questions grounded in a set of transcripts. Every question
+// is sent with the transcripts and the previous exchanges, so that follow-up
+// questions can refer to them.
+type asker struct {
+	client *genai.Client
+	// gen holds the model chain, the streaming setting and the ledger. Once
+	// a model of the chain falls back, the next questions use the fallback.
+	gen    generation
+	corpus string
+	// corpusTokens is the size of the corpus, used to check the budget
+	// before every question.
+	corpusTokens int32
+	history      []exchange
+}
+
+// newAsker returns an asker over the sources.
+func newAsker(ctx context.Context, client *genai.Client, gen generation, sources []source) *asker {
+	a := &asker{client: client, gen: gen, corpus: formatCorpus(sources)}
+	res, err := a.newModel(gen).CountTokens(ctx, genai.Text(a.corpus))
+	if err != nil {
+		logger.Warn("unable to count tokens, estimating them from the length", "error", err)
+		a.corpusTokens = int32(len(a.corpus) / 4)
+	} else {
+		a.corpusTokens = res.TotalTokens
+	}
+	logger.Info("transcripts loaded", "count", len(sources), "tokens", a.corpusTokens)
+	return a
+}
+
+// newModel returns the model of gen, with askInstruction added to the
+// system instruction of the settings.
+func (a *asker) newModel(gen generation) *genai.GenerativeModel {
+	model := gen.newModel(a.client)
+	model.SystemInstruction = genai.NewUserContent(genai.Text(joinInstructions(gen.settings.SystemInstruction, askInstruction)))
+	return model
+}
+
+// parts returns the request for question: the transcripts, the previous
+// exchanges and the question.
+func (a *asker) parts(question string) []genai.Part {
+	parts := []genai.Part{genai.Text(a.corpus)}
+	if len(a.history) > 0 {
+		var b strings.Builder
+		b.WriteString("Previous questions and answers:\n")
+		for _, e := range a.history {
+			fmt.Fprintf(&b, "\nQuestion: %s\nAnswer: %s\n", e.Question, e.Answer)
+		}
+		parts = append(parts, genai.Text(b.String()))
+	}
+	return append(parts, genai.Text("Question: "+question))
+}
+
+// ask answers question and writes the answer to w, as it is generated if
+// the generation streams. The call is not made if its estimated usage
+// exceeds the budget.
+func (a *asker) ask(ctx context.Context, w io.Writer, question string) (string, error) {
+	parts := a.parts(question)
+	estimate := tokenUsage{Prompt: a.corpusTokens, Candidates: synthesisOutputTokens}
+	for _, part := range parts[1:] {
+		estimate.Prompt += int32(len(part.(genai.Text)) / 4)
+	}
+	estimate.Total = estimate.Prompt + estimate.Candidates
+	if err := a.gen.check(phaseQuestion, estimate); err != nil {
+		return "", err
+	}
+
+	var stream io.Writer
+	if a.gen.stream {
+		stream = w
+	}
+	var answer string
+	gen, err := a.gen.withFallback(func(gen generation) error {
+		var (
+			usage tokenUsage
+			err   error
+		)
+		answer, usage, err = generateText(ctx, a.newModel(gen), stream, gen.checker(phaseQuestion), parts...)
+		gen.record(phaseQuestion, "", "", usage)
+		return err
+	})
+	a.gen = gen
+	if err != nil {
+		return "", err
+	}
+	if !a.gen.stream {
+		io.WriteString(w, answer)
+	}
+	io.WriteString(w, "\n\n")
+	a.history = append(a.history, exchange{Question: question, Answer: answer})
+	return answer, flush(w)
+}
+
+// repl reads the questions from r, one per line, and writes the answers to
+// w until the end of the input or an exit command.
+func (a *asker) repl(ctx context.Context, r io.Reader, w io.Writer) error {
+	scanner := bufio.NewScanner(r)
+	for {
+		fmt.Fprint(w, "> ")
+		if !scanner.Scan() {
+			fmt.Fprintln(w)
+			return scanner.Err()
+		}
+		question := strings.TrimSpace(scanner.Text())
+		switch question {
+		case "":
+			continue
+		case "exit", "quit":
+			return nil
+		}
+		if _, err := a.ask(ctx, w, question); err != nil {
+			return err
+		}
+	}
+}
+
+func askCommand(cmd *command, args []string) error {
+	f := newCLIFlags(cmd, flagsCost)
+	var questions stringsFlag
+	f.fs.Var(&questions, "q", "Question to answer. Can be repeated. If none, the questions are read from the standard input, one per line.")
+	f.fs.BoolVar(&f.stream, "stream", false, "Write the answers as they are generated.")
+	config, opts, filePaths, err := f.load(args, "transcript file")
+	if err != nil {
+		return err
+	}
+
+	var sources []source
+	for _, path := range filePaths {
+		s, err := loadTranscripts(path)
+		if err != nil {
+			return fmt.Errorf("failed to load transcripts: %w", err)
+		}
+		sources = append(sources, s...)
+	}
+	labelSources(sources)
+
+	ctx := context.Background()
+	gen := newGeneration(config, opts).forPhase(config, phaseSynthesis)
+	client, err := genai.NewClient(ctx, gen.projectID, gen.location)
+	if err != nil {
+		return fmt.Errorf("unable to create client: %w", err)
+	}
+	defer client.Close()
+
+	a := newAsker(ctx, client, gen, sources)
+	defer func() {
+		usage, cost := gen.ledger.total()
+		logger.Info("usage", "questions", len(a.history), "tokens", usage.Total, "cost_usd", fmt.Sprintf("%.4f", cost))
+	}()
+	if len(questions) == 0 {
+		return a.repl(ctx, os.Stdin, os.Stdout)
+	}
+	for _, question := range questions {
+		fmt.Printf("## %s\n\n", question)
+		if _, err := a.ask(ctx, os.Stdout, question); err != nil {
+			return err
+		}
+	}
+	return nil
+}
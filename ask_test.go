@@ -0,0 +1,33 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/vertexai/genai"
+)
+
+// TestAskerParts tests that the questions are sent with the transcripts and the previous exchanges
+func TestAskerParts(t *testing.T) {
+	a := &asker{corpus: formatCorpus([]source{
+		{Path: "/tmp/interview1.m4a", Transcript: "Speaker A: checkout is slow"},
+		{Path: "interview2.txt", Transcript: "Speaker B: I like it"},
+	})}
+	if !strings.HasPrefix(a.corpus, "<transcript file=\"interview1.m4a\">\nSpeaker A: checkout is slow\n</transcript>\n\n<transcript file=\"interview2.txt\">") {
+		t.Errorf("Unexpected corpus:\n%s", a.corpus)
+	}
+
+	parts := a.parts("What about checkout?")
+	if len(parts) != 2 || parts[1] != genai.Text("Question: What about checkout?") {
+		t.Errorf("Unexpected first request: %v", parts)
+	}
+
+	a.history = append(a.history, exchange{Question: "What about checkout?", Answer: "It is slow [interview1.m4a, Speaker A]."})
+	parts = a.parts("Who said so?")
+	if len(parts) != 3 {
+		t.Fatalf("Expected the previous exchanges in the request, got %v", parts)
+	}
+	if history := string(parts[1].(genai.Text)); !strings.Contains(history, "Question: What about checkout?\nAnswer: It is slow") {
+		t.Errorf("Unexpected history:\n%s", history)
+	}
+}
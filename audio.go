@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// probeDuration returns the duration of an audio file using ffprobe.
+func probeDuration(ctx context.Context, audioFilePath string) (time.Duration, error) {
+	out, err := exec.CommandContext(ctx, "ffprobe",
+		"-v", "error",
+		"-show_entries", "format=duration",
+		"-of", "default=noprint_wrappers=1:nokey=1",
+		audioFilePath).Output()
+	if err != nil {
+		return 0, fmt.Errorf("ffprobe: %w", err)
+	}
+	seconds, err := strconv.ParseFloat(strings.TrimSpace(string(out)), 64)
+	if err != nil {
+		return 0, fmt.Errorf("unable to parse duration %q: %w", out, err)
+	}
+	return time.Duration(seconds * float64(time.Second)).Round(time.Second), nil
+}
+
+// splitAudio splits the audio file into segments of the given duration in
+// dir, without re-encoding, and returns the paths of the segments in order.
+func splitAudio(ctx context.Context, audioFilePath, dir string, segment time.Duration) ([]string, error) {
+	ext := filepath.Ext(audioFilePath)
+	cmd := exec.CommandContext(ctx, "ffmpeg",
+		"-v", "error",
+		"-i", audioFilePath,
+		"-f", "segment",
+		"-segment_time", strconv.Itoa(int(segment.Seconds())),
+		"-c", "copy",
+		"-y",
+		filepath.Join(dir, "chunk_%03d"+ext))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("ffmpeg: %w: %s", err, out)
+	}
+	parts, err := filepath.Glob(filepath.Join(dir, "chunk_*"+ext))
+	if err != nil {
+		return nil, fmt.Errorf("filepath.Glob: %w", err)
+	}
+	if len(parts) == 0 {
+		return nil, errors.New("ffmpeg created no chunk")
+	}
+	sort.Strings(parts)
+	return parts, nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// transcriptCache stores the transcripts on disk so that a recording is not
+// transcribed (and billed) twice with the same models and prompt.
+type transcriptCache struct {
+	dir string
+}
+
+// cacheEntry is a cached transcript.
+type cacheEntry struct {
+	File       string     `json:"file"`
+	Model      string     `json:"model"`
+	Transcript string     `json:"transcript"`
+	Usage      tokenUsage `json:"usage"`
+	Created    time.Time  `json:"created"`
+}
+
+// openTranscriptCache returns the cache in the user cache directory.
+func openTranscriptCache() (*transcriptCache, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return nil, fmt.Errorf("os.UserCacheDir: %w", err)
+	}
+	dir = filepath.Join(dir, "audiotranscribe", "transcripts")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("os.MkdirAll: %w", err)
+	}
+	return &transcriptCache{dir: dir}, nil
+}
+
+// key identifies the transcription of the audio file by its content, the
+// models and settings of gen and the prompt.
+func (c *transcriptCache) key(audioFilePath string, gen generation, prompt string) (string, error) {
+	f, err := os.Open(audioFilePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read audio file: %w", err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("failed to read audio file: %w", err)
+	}
+	settings, err := json.Marshal(gen.settings)
+	if err != nil {
+		return "", fmt.Errorf("json.Marshal: %w", err)
+	}
+	fmt.Fprintf(h, "\x00%s\x00%s\x00%s\x00%s", gen.model, strings.Join(gen.fallbacks, ","), settings, prompt)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (c *transcriptCache) path(key string) string {
+	return filepath.Join(c.dir, key+".json")
+}
+
+// get returns the cached transcript of key, if any.
+func (c *transcriptCache) get(key string) (cacheEntry, bool) {
+	var entry cacheEntry
+	content, err := os.ReadFile(c.path(key))
+	if err != nil {
+		return entry, false
+	}
+	if err := json.Unmarshal(content, &entry); err != nil {
+		logger.Warn("ignoring corrupted cache entry", "file", c.path(key), "error", err)
+		return entry, false
+	}
+	return entry, true
+}
+
+// put stores the transcript of key.
+func (c *transcriptCache) put(key string, entry cacheEntry) error {
+	entry.Created = time.Now()
+	content, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return fmt.Errorf("json.Marshal: %w", err)
+	}
+	f, err := createAtomic(c.path(key), false)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(content); err != nil {
+		f.Abort()
+		return fmt.Errorf("failed to write cache entry: %w", err)
+	}
+	return f.Commit()
+}
+
+// entries returns the cached transcripts, oldest first.
+func (c *transcriptCache) entries() ([]cacheEntry, error) {
+	paths, err := filepath.Glob(filepath.Join(c.dir, "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("filepath.Glob: %w", err)
+	}
+	var entries []cacheEntry
+	for _, path := range paths {
+		if entry, ok := c.get(strings.TrimSuffix(filepath.Base(path), ".json")); ok {
+			entries = append(entries, entry)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Created.Before(entries[j].Created) })
+	return entries, nil
+}
+
+// print writes the cached transcripts as a table.
+func (c *transcriptCache) print(w io.Writer) error {
+	entries, err := c.entries()
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "FILE\tMODEL\tCREATED\tLENGTH\tTOKENS\t")
+	for _, e := range entries {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\t\n", e.File, e.Model, e.Created.Format(time.DateTime), len(e.Transcript), e.Usage.Total)
+	}
+	return tw.Flush()
+}
+
+// clear removes every cached transcript and returns their number.
+func (c *transcriptCache) clear() (int, error) {
+	paths, err := filepath.Glob(filepath.Join(c.dir, "*.json"))
+	if err != nil {
+		return 0, fmt.Errorf("filepath.Glob: %w", err)
+	}
+	n := 0
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return n, fmt.Errorf("os.Remove: %w", err)
+		}
+		n++
+	}
+	return n, nil
+}
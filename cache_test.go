@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestTranscriptCache tests the keys and the storage of the cached transcripts
+func TestTranscriptCache(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	c, err := openTranscriptCache()
+	if err != nil {
+		t.Fatalf("openTranscriptCache failed: %v", err)
+	}
+
+	audio := filepath.Join(t.TempDir(), "audio.m4a")
+	if err := os.WriteFile(audio, []byte("audio content"), 0o644); err != nil {
+		t.Fatalf("failed to write audio file: %v", err)
+	}
+	gen := generation{model: "gemini-2.0-flash", settings: phaseSettings{Temperature: 0.4}}
+	key, err := c.key(audio, gen, "prompt")
+	if err != nil {
+		t.Fatalf("key failed: %v", err)
+	}
+	if again, _ := c.key(audio, gen, "prompt"); again != key {
+		t.Error("Expected the key to be stable")
+	}
+	if other, _ := c.key(audio, gen, "other prompt"); other == key {
+		t.Error("Expected the key to depend on the prompt")
+	}
+	gen.settings.Temperature = 0.2
+	if other, _ := c.key(audio, gen, "prompt"); other == key {
+		t.Error("Expected the key to depend on the settings")
+	}
+
+	if _, ok := c.get(key); ok {
+		t.Error("Expected a miss on an empty cache")
+	}
+	if err := c.put(key, cacheEntry{File: audio, Model: "gemini-2.0-flash", Transcript: "hello"}); err != nil {
+		t.Fatalf("put failed: %v", err)
+	}
+	entry, ok := c.get(key)
+	if !ok || entry.Transcript != "hello" || entry.Created.IsZero() {
+		t.Errorf("Unexpected entry: %+v, %v", entry, ok)
+	}
+
+	n, err := c.clear()
+	if err != nil || n != 1 {
+		t.Errorf("clear() = %d, %v; expected 1 entry removed", n, err)
+	}
+	if entries, _ := c.entries(); len(entries) != 0 {
+		t.Errorf("Expected an empty cache, got %d entries", len(entries))
+	}
+}
@@ -0,0 +1,507 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/kelseyhightower/envconfig"
+)
+
+// command is a subcommand of the CLI.
+type command struct {
+	name string
+	// args describes the positional arguments.
+	args    string
+	summary string
+	// env is true if the command reads the configuration from the
+	// environment.
+	env bool
+	run func(cmd *command, args []string) error
+}
+
+// commands are the subcommands of the CLI. run is the default one, so that
+// `audiotranscribe -o report.md audio.m4a` keeps working.
+var commands []*command
+
+func init() {
+	commands = []*command{
+		{name: "run", args: "[flags] audio1.m4a [audio2.m4a ...]", summary: "Transcribe the audio files and synthesize the transcripts (default command).", env: true, run: runCommand},
+		{name: "transcribe", args: "[flags] audio1.m4a [audio2.m4a ...]", summary: "Transcribe the audio files, without synthesis.", env: true, run: transcribeCommand},
+		{name: "summarize", args: "[flags] report.md|transcript.txt|transcripts.json ...", summary: "Synthesize the transcripts of previous reports, progress files, JSON files or text files without transcribing again.", env: true, run: summarizeCommand},
+		{name: "ask", args: "[flags] report.md|transcript.txt|transcripts.json ...", summary: "Answer questions grounded in the transcripts, citing file and speaker, interactively or with -q.", env: true, run: askCommand},
+		{name: "split", args: "[flags] audio1.m4a [audio2.m4a ...]", summary: "Split the audio files into chunks, as split_and_transcribe.sh does, and print their paths.", run: splitCommand},
+		{name: "cost", args: "[flags] audio1.m4a [audio2.m4a ...]", summary: "Print the planned requests, tokens and cost of a run without transcribing anything.", env: true, run: costCommand},
+		{name: "cache", args: "dir|list|clear", summary: "Manage the cache of transcripts used with -cache.", run: cacheCommand},
+		{name: "serve", args: "[flags]", summary: "Serve the pipeline over HTTP: POST audio files to /transcribe to get the report.", env: true, run: serveCommand},
+		{name: "doctor", args: "[flags]", summary: "Check the tools, the credentials, the models and the bucket the runs depend on.", env: true, run: doctorCommand},
+		{name: "config", args: "show [flags]", summary: "Print the effective configuration once the configuration file, the environment and the flags are merged.", env: true, run: configCommand},
+	}
+}
+
+// lookupCommand returns the command named by the first argument and its
+// arguments; the run command if the first argument is not a command.
+func lookupCommand(args []string) (*command, []string) {
+	if len(args) > 0 {
+		for _, cmd := range commands {
+			if cmd.name == args[0] {
+				return cmd, args[1:]
+			}
+		}
+	}
+	return commands[0], args
+}
+
+// usage prints the help of the command.
+func (c *command) usage(fs *flag.FlagSet) {
+	out := fs.Output()
+	fmt.Fprintf(out, "Usage: %s %s %s\n\n%s\n\n", filepath.Base(os.Args[0]), c.name, c.args, c.summary)
+	if c.name == "run" {
+		fmt.Fprintf(out, "The command can be omitted. Other commands:\n\n")
+		tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		for _, cmd := range commands[1:] {
+			fmt.Fprintf(tw, "  %s\t%s\n", cmd.name, cmd.summary)
+		}
+		tw.Flush()
+		fmt.Fprintf(out, "\nRun '%s <command> -h' for the flags of a command.\n\n", filepath.Base(os.Args[0]))
+	}
+	fmt.Fprintln(out, "Flags:")
+	fs.PrintDefaults()
+	if c.env {
+		fmt.Fprintln(out)
+		envconfig.Usagef("", &configuration{}, out, envconfig.DefaultTableFormat)
+	}
+}
+
+// Groups of flags registered by the commands.
+const (
+	flagsOutput        = 1 << iota // -o, -append and -json
+	flagsReport                    // -template and -stream
+	flagsPrompts                   // prompt profile and variables, glossary and context files
+	flagsTranscription             // blocked content and cache
+	flagsSynthesis                 // -summary-token-limit and the passes run after the synthesis
+	flagsCost                      // prices and budget
+	flagsEstimate                  // -chunk-duration and -offline
+	flagsAll           = 1<<iota - 1
+)
+
+// cliFlags holds the flags of a command. The flags a command does not
+// register keep their default value.
+type cliFlags struct {
+	cmd *command
+	fs  *flag.FlagSet
+
+	configPath string
+	configName string
+	// cfgPath, cfgName and cfgProfile are the configuration file and the
+	// profile applied by parse.
+	cfgPath    string
+	cfgName    string
+	cfgProfile configProfile
+
+	outputFile   string
+	appendOutput bool
+	jsonFile     string
+	tasksFile    string
+	templateFile string
+	stream       bool
+
+	profile      string
+	transPrompt  string
+	sumPrompt    string
+	projectCtx   string
+	language     string
+	speakers     int
+	glossaryFile string
+	guideFile    string
+	contextFiles stringsFlag
+
+	safety    string
+	onBlocked string
+	fallback  string
+	cache     bool
+
+	summaryLimit int
+	quotes       string
+	grounding    bool
+	structured   bool
+	themes       bool
+	personas     bool
+	minutes      bool
+
+	pricesFile string
+	maxCost    float64
+	maxTokens  int
+
+	chunkLength time.Duration
+	offline     bool
+}
+
+// newCLIFlags returns the flags of the command made of the groups.
+func newCLIFlags(cmd *command, groups int) *cliFlags {
+	f := &cliFlags{
+		cmd:          cmd,
+		fs:           flag.NewFlagSet(cmd.name, flag.ExitOnError),
+		profile:      defaultProfile,
+		onBlocked:    "fail",
+		summaryLimit: 500000,
+	}
+	fs := f.fs
+	fs.Usage = func() { cmd.usage(fs) }
+
+	fs.StringVar(&f.configPath, "config", "", "Path to the configuration file. If empty, "+configFileName+" in the current directory, then in the user configuration directory.")
+	fs.StringVar(&f.configName, "config-profile", "", "Profile of the configuration file to use. If empty, its default_profile.")
+	if groups&flagsOutput != 0 {
+		fs.StringVar(&f.outputFile, "o", "", "Path to the output file. If empty, stdout will be used.")
+		fs.BoolVar(&f.appendOutput, "append", false, "Append to the output file instead of replacing it (requires -o).")
+		fs.StringVar(&f.jsonFile, "json", "", "Path to a JSON export of the report: the sources with their transcript, the synthesis, the structured synthesis with -structured, and the usage.")
+	}
+	if groups&flagsReport != 0 {
+		fs.StringVar(&f.templateFile, "template", "", "Path to a text/template file used to render the report. If empty, the built-in template is used.")
+		fs.BoolVar(&f.stream, "stream", false, "Write the transcripts and the synthesis to the progress output as they are generated.")
+	}
+	if groups&flagsPrompts != 0 {
+		fs.StringVar(&f.profile, "profile", defaultProfile, "Prompt profile: a built-in profile name (default, customer-interview, meeting-minutes), a profile in the user configuration directory or a directory path.")
+		fs.StringVar(&f.transPrompt, "transcription-prompt", "", "Path to a file overriding the transcription prompt of the profile.")
+		fs.StringVar(&f.sumPrompt, "summary-prompt", "", "Path to a file overriding the summary prompt of the profile.")
+		fs.StringVar(&f.projectCtx, "project-context", "", "Short description of the project, available to the prompts as {{ .ProjectContext }}.")
+		fs.StringVar(&f.language, "language", "", "Language of the recordings and of the summary, available to the prompts as {{ .Language }}.")
+		fs.IntVar(&f.speakers, "speakers", 0, "Expected number of speakers, available to the prompts as {{ .Speakers }}.")
+		fs.StringVar(&f.glossaryFile, "glossary", "", "Path to a list of terms (one per line) whose spelling is enforced in the transcripts.")
+		fs.Var(&f.contextFiles, "context-file", "Path to a project document (brief, glossary, research questions...) framing the synthesis. Can be repeated.")
+	}
+	if groups&flagsTranscription != 0 {
+		fs.StringVar(&f.safety, "safety-threshold", "", "Block threshold applied to every harm category: low_and_above, medium_and_above, only_high or none. If empty, the defaults of the API apply.")
+		fs.StringVar(&f.onBlocked, "on-blocked", "fail", "What to do when the model blocks a file: fail, or an ordered list of model (retry with -fallback-model), split (retry on two halves) and skip.")
+		fs.StringVar(&f.fallback, "fallback-model", "", "Model used to retry a blocked file with -on-blocked=model.")
+		fs.BoolVar(&f.cache, "cache", false, "Reuse the transcripts of the files already transcribed with the same models and prompt, and cache the new ones.")
+	}
+	if groups&flagsSynthesis != 0 {
+		fs.IntVar(&f.summaryLimit, "summary-token-limit", 500000, "Number of tokens above which the transcripts are summarized individually before the final synthesis.")
+		fs.StringVar(&f.quotes, "quotes", "", "Extract the key quotes supporting the synthesis and look for them in the transcripts: flag marks the quotes not found, drop removes them. If empty, no quotes are extracted.")
+		fs.BoolVar(&f.structured, "structured", false, "Ask for a synthesis following a JSON schema (themes, takeaways, pitfalls, action items, open questions and quotes), validated and rendered as markdown.")
+		fs.BoolVar(&f.themes, "themes", false, "Cluster the findings into themes across the interviews, with the interviews, speakers and a representative quote supporting each, in a table.")
+		fs.BoolVar(&f.personas, "personas", false, "Draft personas (goals, frustrations, behaviors, quotes) and a journey map with its pain points, every element with the quotes it is drawn from.")
+		fs.BoolVar(&f.minutes, "minutes", false, "Extract the decisions, the action items (owner, due date, source utterance) and the open questions of meetings in a Minutes section.")
+		fs.StringVar(&f.tasksFile, "tasks", "", "Path to an export of the minutes (implies -minutes): the action items as CSV if the path ends with .csv, the decisions, action items and open questions as JSON otherwise.")
+		fs.StringVar(&f.guideFile, "guide", "", "Path to a discussion guide (the items of its lists are the questions) whose coverage by every interview is added to the report as a matrix.")
+		fs.BoolVar(&f.grounding, "grounding", false, "Check every item of the lists of the synthesis against the transcripts and add the supporting passages, or unsupported, in an appendix.")
+	}
+	if groups&flagsCost != 0 {
+		fs.StringVar(&f.pricesFile, "prices", "", "Path to a JSON file with the price of the models in USD per million tokens, e.g. {\"gemini-2.0-flash\": {\"input\": 0.15, \"audio_input\": 1.0, \"output\": 0.6}}.")
+		fs.Float64Var(&f.maxCost, "max-cost", 0, "Stop the run before its estimated cost exceeds this amount in USD; 0 for no limit. The partial report is saved.")
+		fs.IntVar(&f.maxTokens, "max-tokens", 0, "Stop the run before it uses more than this number of tokens; 0 for no limit. The partial report is saved.")
+	}
+	if groups&flagsEstimate != 0 {
+		fs.DurationVar(&f.chunkLength, "chunk-duration", 0, "For the estimate, duration of the chunks the files are split into beforehand (e.g. 25m, as split_and_transcribe.sh does); 0 for the files as they are, since run does not split them.")
+		fs.BoolVar(&f.offline, "offline", false, "For the estimate, use the audio duration instead of calling the CountTokens API.")
+	}
+	return f
+}
+
+// parse parses the arguments and applies the configuration file: its
+// profile sets the flags that are not on the command line.
+func (f *cliFlags) parse(args []string) error {
+	f.fs.Parse(args)
+
+	path, err := findConfigFile(f.configPath)
+	if err != nil {
+		return err
+	}
+	f.cfgPath = path
+	f.cfgName, f.cfgProfile, err = loadConfigProfile(path, f.configName)
+	if err != nil {
+		return err
+	}
+	if err := f.cfgProfile.apply(f.fs); err != nil {
+		return fmt.Errorf("failed to apply the configuration profile %s of %s: %w", f.cfgName, path, err)
+	}
+	return nil
+}
+
+// files returns the positional arguments, or an error if there is none.
+func (f *cliFlags) files(what string) ([]string, error) {
+	if f.fs.NArg() == 0 {
+		f.fs.Usage()
+		return nil, fmt.Errorf("at least one %s required as argument", what)
+	}
+	return f.fs.Args(), nil
+}
+
+// config reads the configuration from the environment and validates it.
+func (f *cliFlags) config() (configuration, error) {
+	var config configuration
+	if err := envconfig.Process("", &config); err != nil {
+		return config, fmt.Errorf("failed to process environment variables: %w", err)
+	}
+	if err := config.validate(); err != nil {
+		return config, fmt.Errorf("invalid configuration: %w", err)
+	}
+	return config, nil
+}
+
+// options loads the files given by the flags and returns the options of the
+// run.
+func (f *cliFlags) options() (options, error) {
+	if f.appendOutput && f.outputFile == "" {
+		return options{}, errors.New("-append requires an output file")
+	}
+
+	tmpl, err := loadReportTemplate(f.templateFile)
+	if err != nil {
+		return options{}, fmt.Errorf("failed to load the report template %s: %w", f.templateFile, err)
+	}
+
+	prompts, err := loadPrompts(f.profile, f.transPrompt, f.sumPrompt, promptData{
+		ProjectContext: f.projectCtx,
+		Language:       f.language,
+		Speakers:       f.speakers,
+	})
+	if err != nil {
+		return options{}, fmt.Errorf("failed to load the prompts of profile %s: %w", f.profile, err)
+	}
+
+	projectContext, err := loadContext(f.contextFiles)
+	if err != nil {
+		return options{}, fmt.Errorf("failed to load the context files: %w", err)
+	}
+
+	var terms glossary
+	if f.glossaryFile != "" {
+		terms, err = loadGlossary(f.glossaryFile)
+		if err != nil {
+			return options{}, fmt.Errorf("failed to load the glossary %s: %w", f.glossaryFile, err)
+		}
+	}
+
+	var guide []guideQuestion
+	if f.guideFile != "" {
+		guide, err = loadGuide(f.guideFile)
+		if err != nil {
+			return options{}, fmt.Errorf("failed to load the guide %s: %w", f.guideFile, err)
+		}
+	}
+
+	safetySettings, err := safetySettings(f.safety)
+	if err != nil {
+		return options{}, fmt.Errorf("invalid -safety-threshold: %w", err)
+	}
+	prices, err := loadPrices(f.pricesFile, f.cfgProfile.Prices)
+	if err != nil {
+		return options{}, fmt.Errorf("failed to load the prices %s: %w", f.pricesFile, err)
+	}
+	if f.maxCost < 0 || f.maxTokens < 0 {
+		return options{}, errors.New("-max-cost and -max-tokens must be positive")
+	}
+	if f.maxTokens > math.MaxInt32 {
+		return options{}, fmt.Errorf("-max-tokens must be at most %d", math.MaxInt32)
+	}
+	if f.summaryLimit < 1 || f.summaryLimit > math.MaxInt32 {
+		return options{}, fmt.Errorf("-summary-token-limit must be between 1 and %d", math.MaxInt32)
+	}
+	strategies, err := parseOnBlocked(f.onBlocked)
+	if err != nil {
+		return options{}, fmt.Errorf("invalid -on-blocked: %w", err)
+	}
+	quotesMode, err := parseQuotesMode(f.quotes)
+	if err != nil {
+		return options{}, fmt.Errorf("invalid -quotes: %w", err)
+	}
+
+	return options{
+		outputFile:   f.outputFile,
+		appendMode:   f.appendOutput,
+		template:     tmpl,
+		prompts:      prompts,
+		contextFiles: f.contextFiles,
+		context:      projectContext,
+		glossary:     terms,
+
+		summaryTokenLimit: int32(f.summaryLimit),
+		quotes:            quotesMode,
+		grounding:         f.grounding,
+		structured:        f.structured,
+		jsonFile:          f.jsonFile,
+		themes:            f.themes,
+		personas:          f.personas,
+		minutes:           f.minutes || f.tasksFile != "",
+		tasksFile:         f.tasksFile,
+		guide:             guide,
+		safetySettings:    safetySettings,
+		onBlocked:         strategies,
+		fallbackModel:     f.fallback,
+		cache:             f.cache,
+		stream:            f.stream,
+		prices:            prices,
+		budget:            budget{MaxCost: f.maxCost, MaxTokens: int32(f.maxTokens)},
+	}, nil
+}
+
+// load parses the arguments and returns the configuration, the options and
+// the files of a pipeline command.
+func (f *cliFlags) load(args []string, what string) (configuration, options, []string, error) {
+	if err := f.parse(args); err != nil {
+		return configuration{}, options{}, nil, err
+	}
+	filePaths, err := f.files(what)
+	if err != nil {
+		return configuration{}, options{}, nil, err
+	}
+	config, err := f.config()
+	if err != nil {
+		return config, options{}, nil, err
+	}
+	opts, err := f.options()
+	return config, opts, filePaths, err
+}
+
+func runCommand(cmd *command, args []string) error {
+	f := newCLIFlags(cmd, flagsAll)
+	dryRun := f.fs.Bool("dry-run", false, "Print the planned requests, tokens and cost without transcribing anything (same as the cost command).")
+	config, opts, filePaths, err := f.load(args, "audio file")
+	if err != nil {
+		return err
+	}
+	if *dryRun {
+		return estimate(config, opts, filePaths, f)
+	}
+	return run(config, opts, filePaths)
+}
+
+func transcribeCommand(cmd *command, args []string) error {
+	f := newCLIFlags(cmd, flagsOutput|flagsReport|flagsPrompts|flagsTranscription|flagsCost)
+	config, opts, filePaths, err := f.load(args, "audio file")
+	if err != nil {
+		return err
+	}
+	opts.skipSynthesis = true
+	return run(config, opts, filePaths)
+}
+
+func summarizeCommand(cmd *command, args []string) error {
+	f := newCLIFlags(cmd, flagsOutput|flagsReport|flagsPrompts|flagsSynthesis|flagsCost)
+	config, opts, filePaths, err := f.load(args, "transcript file")
+	if err != nil {
+		return err
+	}
+	opts.fromTranscripts = true
+	return run(config, opts, filePaths)
+}
+
+func costCommand(cmd *command, args []string) error {
+	f := newCLIFlags(cmd, flagsPrompts|flagsSynthesis|flagsCost|flagsEstimate)
+	config, opts, filePaths, err := f.load(args, "audio file")
+	if err != nil {
+		return err
+	}
+	return estimate(config, opts, filePaths, f)
+}
+
+// estimate prints the planned usage of a run.
+func estimate(config configuration, opts options, filePaths []string, f *cliFlags) error {
+	gen := newGeneration(config, opts)
+	est, err := estimateRun(context.Background(), gen.forPhase(config, phaseTranscription), gen.forPhase(config, phaseSynthesis), opts, filePaths, f.chunkLength, f.offline)
+	if err != nil {
+		return fmt.Errorf("dry run failed: %w", err)
+	}
+	if err := est.print(os.Stdout); err != nil {
+		return err
+	}
+	if err := opts.budget.exceeded(est.total()); err != nil {
+		logger.Warn("the run is likely to stop before completion", "error", err)
+	}
+	return nil
+}
+
+func splitCommand(cmd *command, args []string) error {
+	f := newCLIFlags(cmd, 0)
+	f.fs.DurationVar(&f.chunkLength, "chunk-duration", defaultChunkDuration, "Duration of the chunks.")
+	dir := f.fs.String("dir", "", "Directory of the chunks. If empty, a <name>_chunks directory next to each file.")
+	if err := f.parse(args); err != nil {
+		return err
+	}
+	filePaths, err := f.files("audio file")
+	if err != nil {
+		return err
+	}
+	if f.chunkLength < time.Second {
+		return errors.New("-chunk-duration must be at least 1s")
+	}
+
+	for _, path := range filePaths {
+		out := *dir
+		if out == "" {
+			out = strings.TrimSuffix(path, filepath.Ext(path)) + "_chunks"
+		} else if len(filePaths) > 1 {
+			base := filepath.Base(path)
+			out = filepath.Join(out, strings.TrimSuffix(base, filepath.Ext(base)))
+		}
+		if err := os.MkdirAll(out, 0o755); err != nil {
+			return fmt.Errorf("os.MkdirAll: %w", err)
+		}
+		parts, err := splitAudio(context.Background(), path, out, f.chunkLength)
+		if err != nil {
+			return fmt.Errorf("failed to split %s: %w", path, err)
+		}
+		logger.Info("audio file split", "file", path, "chunks", len(parts), "dir", out)
+		for _, part := range parts {
+			fmt.Println(part)
+		}
+	}
+	return nil
+}
+
+func cacheCommand(cmd *command, args []string) error {
+	f := newCLIFlags(cmd, 0)
+	if err := f.parse(args); err != nil {
+		return err
+	}
+	c, err := openTranscriptCache()
+	if err != nil {
+		return err
+	}
+	switch f.fs.Arg(0) {
+	case "dir":
+		fmt.Println(c.dir)
+		return nil
+	case "list":
+		return c.print(os.Stdout)
+	case "clear":
+		n, err := c.clear()
+		if err != nil {
+			return err
+		}
+		logger.Info("cache cleared", "transcripts", n, "dir", c.dir)
+		return nil
+	default:
+		f.fs.Usage()
+		return fmt.Errorf("unknown cache action %q (expected dir, list or clear)", f.fs.Arg(0))
+	}
+}
+
+func configCommand(cmd *command, args []string) error {
+	f := newCLIFlags(cmd, flagsAll)
+	if len(args) == 0 || args[0] != "show" {
+		f.fs.Usage()
+		return errors.New("unknown config action (expected show)")
+	}
+	if err := f.parse(args[1:]); err != nil {
+		return err
+	}
+	var config configuration
+	if err := envconfig.Process("", &config); err != nil {
+		logger.Warn("incomplete configuration", "error", err)
+	}
+	prices, err := loadPrices(f.pricesFile, f.cfgProfile.Prices)
+	if err != nil {
+		return fmt.Errorf("failed to load the prices %s: %w", f.pricesFile, err)
+	}
+	return printConfig(os.Stdout, f.cfgPath, f.cfgName, effectiveProfile(config, f.fs, prices))
+}
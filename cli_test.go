@@ -0,0 +1,110 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestLookupCommand tests that run is the default command
+func TestLookupCommand(t *testing.T) {
+	tests := []struct {
+		args     []string
+		name     string
+		nbRemain int
+	}{
+		{nil, "run", 0},
+		{[]string{"-o", "report.md", "audio.m4a"}, "run", 3},
+		{[]string{"audio.m4a"}, "run", 1},
+		{[]string{"transcribe", "audio.m4a"}, "transcribe", 1},
+		{[]string{"config", "show"}, "config", 1},
+	}
+	for _, tt := range tests {
+		cmd, args := lookupCommand(tt.args)
+		if cmd.name != tt.name || len(args) != tt.nbRemain {
+			t.Errorf("lookupCommand(%q) = %s, %q; expected %s with %d arguments", tt.args, cmd.name, args, tt.name, tt.nbRemain)
+		}
+	}
+}
+
+// TestNewCLIFlags tests that the commands only register the flags of their groups
+func TestNewCLIFlags(t *testing.T) {
+	cmd := &command{name: "test"}
+	f := newCLIFlags(cmd, flagsOutput|flagsCost)
+	for _, name := range []string{"config", "config-profile", "o", "append", "max-cost", "prices"} {
+		if f.fs.Lookup(name) == nil {
+			t.Errorf("Expected flag -%s to be registered", name)
+		}
+	}
+	for _, name := range []string{"profile", "template", "on-blocked", "chunk-duration", "tasks"} {
+		if f.fs.Lookup(name) != nil {
+			t.Errorf("Expected flag -%s not to be registered", name)
+		}
+	}
+	// The passes run after the synthesis are not flags of transcribe.
+	f = newCLIFlags(cmd, flagsPrompts|flagsTranscription)
+	for _, name := range []string{"guide", "tasks", "minutes"} {
+		if f.fs.Lookup(name) != nil {
+			t.Errorf("Expected flag -%s not to be registered without synthesis", name)
+		}
+	}
+	// The flags not registered keep their default value.
+	if f.onBlocked != "fail" || f.profile != defaultProfile || f.summaryLimit != 500000 {
+		t.Errorf("Unexpected defaults: %q, %q, %d", f.onBlocked, f.profile, f.summaryLimit)
+	}
+}
+
+// TestCLIFlagsConfigProfile tests that a profile setting flags a command does not register is ignored
+func TestCLIFlagsConfigProfile(t *testing.T) {
+	path := writeTestConfig(t, testConfigFile)
+	_, profile, err := loadConfigProfile(path, "interviews")
+	if err != nil {
+		t.Fatalf("loadConfigProfile failed: %v", err)
+	}
+	for name := range profile.environ() {
+		t.Setenv(name, "")
+	}
+
+	f := newCLIFlags(&command{name: "test"}, flagsCost)
+	if err := f.parse([]string{"-config", path}); err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if f.maxCost != 5 {
+		t.Errorf("Expected the profile to set -max-cost, got %v", f.maxCost)
+	}
+	if f.language != "" {
+		t.Errorf("Expected -language to be left unset, got %q", f.language)
+	}
+}
+
+// TestCLIFlagsMaxTokens tests that -max-tokens is limited to the range of the budget
+func TestCLIFlagsMaxTokens(t *testing.T) {
+	f := newCLIFlags(&command{name: "test"}, flagsCost)
+	if err := f.fs.Parse([]string{"-max-tokens", "3000000000"}); err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if _, err := f.options(); err == nil || !strings.Contains(err.Error(), "-max-tokens") {
+		t.Errorf("Expected -max-tokens to be rejected, got %v", err)
+	}
+
+	f.maxTokens = 1000
+	opts, err := f.options()
+	if err != nil {
+		t.Fatalf("options failed: %v", err)
+	}
+	if opts.budget.MaxTokens != 1000 {
+		t.Errorf("Expected a budget of 1000 tokens, got %d", opts.budget.MaxTokens)
+	}
+}
+
+// TestCLIFlagsSummaryTokenLimit tests that -summary-token-limit is limited to the range of the token counts
+func TestCLIFlagsSummaryTokenLimit(t *testing.T) {
+	for _, limit := range []string{"0", "-1", "3000000000"} {
+		f := newCLIFlags(&command{name: "test"}, flagsSynthesis)
+		if err := f.fs.Parse([]string{"-summary-token-limit", limit}); err != nil {
+			t.Fatalf("parse failed: %v", err)
+		}
+		if _, err := f.options(); err == nil || !strings.Contains(err.Error(), "-summary-token-limit") {
+			t.Errorf("Expected -summary-token-limit %s to be rejected, got %v", limit, err)
+		}
+	}
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// configFileName is the name of the configuration file, looked up in the
+// current directory and then in the user configuration directory.
+const configFileName = "audiotranscribe.yaml"
+
+// configFile is the content of audiotranscribe.yaml: named profiles bundling
+// the settings of a kind of run.
+type configFile struct {
+	// DefaultProfile is used when no profile is selected with
+	// -config-profile.
+	DefaultProfile string                   `yaml:"default_profile,omitempty"`
+	Profiles       map[string]configProfile `yaml:"profiles"`
+}
+
+// configProfile holds the settings of a profile. Each setting is the default
+// value of an environment variable or of a flag, which take precedence.
+type configProfile struct {
+	Project       string          `yaml:"project,omitempty"`
+	Region        string          `yaml:"region,omitempty"`
+	Model         string          `yaml:"model,omitempty"`
+	GCSBucket     string          `yaml:"gcs_bucket,omitempty"`
+	Transcription phaseProfile    `yaml:"transcription,omitempty"`
+	Synthesis     phaseProfile    `yaml:"synthesis,omitempty"`
+	Prompts       promptsProfile  `yaml:"prompts,omitempty"`
+	Output        outputProfile   `yaml:"output,omitempty"`
+	Chunking      chunkingProfile `yaml:"chunking,omitempty"`
+	Budget        budgetProfile   `yaml:"budget,omitempty"`
+	// Prices override the default prices; -prices overrides them.
+	Prices priceTable `yaml:"prices,omitempty"`
+}
+
+// phaseProfile holds the GEMINI_TRANSCRIPTION_* or GEMINI_SYNTHESIS_*
+// settings.
+type phaseProfile struct {
+	Model             string   `yaml:"model,omitempty"`
+	Temperature       *float32 `yaml:"temperature,omitempty"`
+	TopP              *float32 `yaml:"top_p,omitempty"`
+	TopK              *int32   `yaml:"top_k,omitempty"`
+	MaxOutputTokens   *int32   `yaml:"max_output_tokens,omitempty"`
+	SystemInstruction string   `yaml:"system_instruction,omitempty"`
+}
+
+type promptsProfile struct {
+	Profile        string   `yaml:"profile,omitempty"`
+	Transcription  string   `yaml:"transcription,omitempty"`
+	Summary        string   `yaml:"summary,omitempty"`
+	ProjectContext string   `yaml:"project_context,omitempty"`
+	Language       string   `yaml:"language,omitempty"`
+	Speakers       int      `yaml:"speakers,omitempty"`
+	Glossary       string   `yaml:"glossary,omitempty"`
+	ContextFiles   []string `yaml:"context_files,omitempty"`
+}
+
+type outputProfile struct {
+	Template string `yaml:"template,omitempty"`
+	Stream   bool   `yaml:"stream,omitempty"`
+}
+
+type chunkingProfile struct {
+	ChunkDuration     string `yaml:"chunk_duration,omitempty"`
+	SummaryTokenLimit int    `yaml:"summary_token_limit,omitempty"`
+}
+
+type budgetProfile struct {
+	MaxCost   float64 `yaml:"max_cost,omitempty"`
+	MaxTokens int     `yaml:"max_tokens,omitempty"`
+}
+
+// findConfigFile returns the path of the configuration file: path if set,
+// otherwise audiotranscribe.yaml in the current directory or in the user
+// configuration directory. It returns an empty path if there is none.
+func findConfigFile(path string) (string, error) {
+	if path != "" {
+		if _, err := os.Stat(path); err != nil {
+			return "", fmt.Errorf("unable to read configuration file: %w", err)
+		}
+		return path, nil
+	}
+	candidates := []string{configFileName}
+	if dir, err := os.UserConfigDir(); err == nil {
+		candidates = append(candidates, filepath.Join(dir, "audiotranscribe", configFileName))
+	}
+	for _, candidate := range candidates {
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate, nil
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return "", fmt.Errorf("unable to read configuration file: %w", err)
+		}
+	}
+	return "", nil
+}
+
+// loadConfigProfile reads the configuration file at path and returns the
+// profile name, or the default profile if name is empty. Relative paths of
+// the profile are resolved from the directory of the file. An empty path
+// gives an empty profile.
+func loadConfigProfile(path, name string) (string, configProfile, error) {
+	if path == "" {
+		return name, configProfile{}, nil
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", configProfile{}, fmt.Errorf("unable to read configuration file: %w", err)
+	}
+	var file configFile
+	dec := yaml.NewDecoder(bytes.NewReader(content))
+	dec.KnownFields(true)
+	if err := dec.Decode(&file); err != nil && !errors.Is(err, io.EOF) {
+		return "", configProfile{}, fmt.Errorf("unable to parse %s: %w", path, err)
+	}
+
+	if name == "" {
+		name = file.DefaultProfile
+	}
+	if name == "" {
+		if _, ok := file.Profiles["default"]; !ok {
+			return "", configProfile{}, nil
+		}
+		name = "default"
+	}
+	profile, ok := file.Profiles[name]
+	if !ok {
+		var names []string
+		for n := range file.Profiles {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return "", configProfile{}, fmt.Errorf("no profile %q in %s (available: %s)", name, path, strings.Join(names, ", "))
+	}
+	profile.resolvePaths(filepath.Dir(path))
+	return name, profile, nil
+}
+
+// resolvePaths makes the relative paths of the profile relative to dir.
+func (p *configProfile) resolvePaths(dir string) {
+	resolve := func(path *string) {
+		if *path != "" && !filepath.IsAbs(*path) {
+			*path = filepath.Join(dir, *path)
+		}
+	}
+	resolve(&p.Prompts.Transcription)
+	resolve(&p.Prompts.Summary)
+	resolve(&p.Prompts.Glossary)
+	resolve(&p.Output.Template)
+	for i := range p.Prompts.ContextFiles {
+		resolve(&p.Prompts.ContextFiles[i])
+	}
+	// A prompt profile is either a name or a directory.
+	if strings.ContainsRune(p.Prompts.Profile, filepath.Separator) {
+		resolve(&p.Prompts.Profile)
+	}
+}
+
+// environ returns the environment variables set by the profile.
+func (p configProfile) environ() map[string]string {
+	env := make(map[string]string)
+	set := func(name, value string) {
+		if value != "" {
+			env[name] = value
+		}
+	}
+	set("GCP_PROJECT", p.Project)
+	set("GCP_REGION", p.Region)
+	set("GEMINI_MODEL", p.Model)
+	set("GCS_BUCKET", p.GCSBucket)
+	for prefix, phase := range map[string]phaseProfile{
+		"GEMINI_TRANSCRIPTION": p.Transcription,
+		"GEMINI_SYNTHESIS":     p.Synthesis,
+	} {
+		set(prefix+"_MODEL", phase.Model)
+		set(prefix+"_SYSTEM_INSTRUCTION", phase.SystemInstruction)
+		if phase.Temperature != nil {
+			set(prefix+"_TEMPERATURE", strconv.FormatFloat(float64(*phase.Temperature), 'g', -1, 32))
+		}
+		if phase.TopP != nil {
+			set(prefix+"_TOP_P", strconv.FormatFloat(float64(*phase.TopP), 'g', -1, 32))
+		}
+		if phase.TopK != nil {
+			set(prefix+"_TOP_K", strconv.Itoa(int(*phase.TopK)))
+		}
+		if phase.MaxOutputTokens != nil {
+			set(prefix+"_MAX_OUTPUT_TOKENS", strconv.Itoa(int(*phase.MaxOutputTokens)))
+		}
+	}
+	return env
+}
+
+// flags returns the flag values set by the profile; a repeatable flag may
+// have several values.
+func (p configProfile) flags() map[string][]string {
+	flags := make(map[string][]string)
+	set := func(name, value string) {
+		if value != "" && value != "0" && value != "false" {
+			flags[name] = append(flags[name], value)
+		}
+	}
+	set("profile", p.Prompts.Profile)
+	set("transcription-prompt", p.Prompts.Transcription)
+	set("summary-prompt", p.Prompts.Summary)
+	set("project-context", p.Prompts.ProjectContext)
+	set("language", p.Prompts.Language)
+	set("speakers", strconv.Itoa(p.Prompts.Speakers))
+	set("glossary", p.Prompts.Glossary)
+	for _, file := range p.Prompts.ContextFiles {
+		set("context-file", file)
+	}
+	set("template", p.Output.Template)
+	set("stream", strconv.FormatBool(p.Output.Stream))
+	set("chunk-duration", p.Chunking.ChunkDuration)
+	set("summary-token-limit", strconv.Itoa(p.Chunking.SummaryTokenLimit))
+	set("max-cost", strconv.FormatFloat(p.Budget.MaxCost, 'g', -1, 64))
+	set("max-tokens", strconv.Itoa(p.Budget.MaxTokens))
+	return flags
+}
+
+// apply sets the environment variables and the flags of the profile that
+// are not already set, so that the environment and the command line keep
+// precedence over the configuration file.
+func (p configProfile) apply(fs *flag.FlagSet) error {
+	for name, value := range p.environ() {
+		if _, ok := os.LookupEnv(name); !ok {
+			if err := os.Setenv(name, value); err != nil {
+				return fmt.Errorf("os.Setenv: %w", err)
+			}
+		}
+	}
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	for name, values := range p.flags() {
+		// The flags of a profile apply to the commands defining them.
+		if explicit[name] || fs.Lookup(name) == nil {
+			continue
+		}
+		for _, value := range values {
+			if err := fs.Set(name, value); err != nil {
+				return fmt.Errorf("invalid %s in the configuration profile: %w", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// effectiveProfile returns the settings in use once the configuration file,
+// the environment and the flags are merged.
+func effectiveProfile(config configuration, fs *flag.FlagSet, prices priceTable) configProfile {
+	get := func(name string) string {
+		if f := fs.Lookup(name); f != nil {
+			return f.Value.String()
+		}
+		return ""
+	}
+	atoi := func(name string) int {
+		n, _ := strconv.Atoi(get(name))
+		return n
+	}
+	maxCost, _ := strconv.ParseFloat(get("max-cost"), 64)
+	stream, _ := strconv.ParseBool(get("stream"))
+	var contextFiles []string
+	if f := fs.Lookup("context-file"); f != nil {
+		contextFiles = *f.Value.(*stringsFlag)
+	}
+
+	return configProfile{
+		Project:       config.GCPProject,
+		Region:        config.GCPRegion,
+		Model:         config.GeminiModel,
+		GCSBucket:     config.GCSBucket,
+		Transcription: config.Transcription.profile(),
+		Synthesis:     config.Synthesis.profile(),
+		Prompts: promptsProfile{
+			Profile:        get("profile"),
+			Transcription:  get("transcription-prompt"),
+			Summary:        get("summary-prompt"),
+			ProjectContext: get("project-context"),
+			Language:       get("language"),
+			Speakers:       atoi("speakers"),
+			Glossary:       get("glossary"),
+			ContextFiles:   contextFiles,
+		},
+		Output: outputProfile{
+			Template: get("template"),
+			Stream:   stream,
+		},
+		Chunking: chunkingProfile{
+			ChunkDuration:     get("chunk-duration"),
+			SummaryTokenLimit: atoi("summary-token-limit"),
+		},
+		Budget: budgetProfile{
+			MaxCost:   maxCost,
+			MaxTokens: atoi("max-tokens"),
+		},
+		Prices: prices,
+	}
+}
+
+// profile returns the settings as they are written in a configuration file.
+func (p phaseSettings) profile() phaseProfile {
+	temperature := p.Temperature
+	return phaseProfile{
+		Model:             p.Model,
+		Temperature:       &temperature,
+		TopP:              p.TopP,
+		TopK:              p.TopK,
+		MaxOutputTokens:   p.MaxOutputTokens,
+		SystemInstruction: p.SystemInstruction,
+	}
+}
+
+// printConfig writes the effective configuration as a profile of a
+// configuration file.
+func printConfig(w io.Writer, path, name string, profile configProfile) error {
+	source := "no configuration file"
+	if path != "" {
+		source = path
+		if name != "" {
+			source += ", profile " + name
+		}
+	}
+	if _, err := fmt.Fprintf(w, "# Effective configuration (%s; environment variables and flags take precedence)\n", source); err != nil {
+		return err
+	}
+	if name == "" {
+		name = "default"
+	}
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(configFile{Profiles: map[string]configProfile{name: profile}}); err != nil {
+		return fmt.Errorf("unable to encode configuration: %w", err)
+	}
+	return enc.Close()
+}
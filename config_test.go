@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testConfigFile = `default_profile: interviews
+profiles:
+  interviews:
+    project: my-project
+    region: us-central1
+    model: gemini-2.5-flash,gemini-2.0-flash
+    synthesis:
+      model: gemini-2.5-pro
+      temperature: 0.2
+    prompts:
+      profile: customer-interview
+      language: French
+      glossary: terms.txt
+      context_files: [brief.md, /abs/guide.md]
+    budget:
+      max_cost: 5
+  meetings:
+    prompts:
+      profile: ./profiles/minutes
+`
+
+func writeTestConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), configFileName)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("failed to write configuration: %v", err)
+	}
+	return path
+}
+
+// TestLoadConfigProfile tests the selection of the profile and the resolution of its paths
+func TestLoadConfigProfile(t *testing.T) {
+	path := writeTestConfig(t, testConfigFile)
+	dir := filepath.Dir(path)
+
+	name, profile, err := loadConfigProfile(path, "")
+	if err != nil {
+		t.Fatalf("loadConfigProfile failed: %v", err)
+	}
+	if name != "interviews" || profile.Project != "my-project" {
+		t.Errorf("Expected the default profile, got %q: %+v", name, profile)
+	}
+	if profile.Prompts.Glossary != filepath.Join(dir, "terms.txt") {
+		t.Errorf("Expected the glossary to be resolved from the file directory, got %q", profile.Prompts.Glossary)
+	}
+	if profile.Prompts.ContextFiles[1] != "/abs/guide.md" {
+		t.Errorf("Expected absolute paths to be kept, got %q", profile.Prompts.ContextFiles[1])
+	}
+	if profile.Prompts.Profile != "customer-interview" {
+		t.Errorf("Expected a prompt profile name to be kept, got %q", profile.Prompts.Profile)
+	}
+
+	_, profile, err = loadConfigProfile(path, "meetings")
+	if err != nil {
+		t.Fatalf("loadConfigProfile failed: %v", err)
+	}
+	if profile.Prompts.Profile != filepath.Join(dir, "profiles", "minutes") {
+		t.Errorf("Expected a prompt profile directory to be resolved, got %q", profile.Prompts.Profile)
+	}
+
+	if _, _, err := loadConfigProfile(path, "unknown"); err == nil || !strings.Contains(err.Error(), "interviews, meetings") {
+		t.Errorf("Expected an error listing the profiles, got %v", err)
+	}
+	if _, _, err := loadConfigProfile(writeTestConfig(t, "profiles:\n  a:\n    modle: x\n"), ""); err == nil {
+		t.Error("Expected an error for an unknown field")
+	}
+	if name, _, err := loadConfigProfile("", ""); err != nil || name != "" {
+		t.Errorf("Expected an empty profile without configuration file, got %q, %v", name, err)
+	}
+}
+
+// TestConfigProfileApply tests that the environment and the flags take precedence over the profile
+func TestConfigProfileApply(t *testing.T) {
+	_, profile, err := loadConfigProfile(writeTestConfig(t, testConfigFile), "interviews")
+	if err != nil {
+		t.Fatalf("loadConfigProfile failed: %v", err)
+	}
+
+	// Restore the variables set by apply after the test.
+	for name := range profile.environ() {
+		t.Setenv(name, "")
+		os.Unsetenv(name)
+	}
+	t.Setenv("GCP_REGION", "europe-west1")
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	language := fs.String("language", "", "")
+	promptProfile := fs.String("profile", defaultProfile, "")
+	maxCost := fs.Float64("max-cost", 0, "")
+	var contextFiles stringsFlag
+	fs.Var(&contextFiles, "context-file", "")
+	fs.String("glossary", "", "")
+	if err := fs.Parse([]string{"-language", "English"}); err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	if err := profile.apply(fs); err != nil {
+		t.Fatalf("apply failed: %v", err)
+	}
+
+	if os.Getenv("GCP_PROJECT") != "my-project" || os.Getenv("GEMINI_SYNTHESIS_TEMPERATURE") != "0.2" {
+		t.Errorf("Expected the profile to set the environment, got %q and %q", os.Getenv("GCP_PROJECT"), os.Getenv("GEMINI_SYNTHESIS_TEMPERATURE"))
+	}
+	if os.Getenv("GCP_REGION") != "europe-west1" {
+		t.Errorf("Expected the environment to take precedence, got %q", os.Getenv("GCP_REGION"))
+	}
+	if *language != "English" {
+		t.Errorf("Expected the command line to take precedence, got %q", *language)
+	}
+	if *promptProfile != "customer-interview" || *maxCost != 5 || len(contextFiles) != 2 {
+		t.Errorf("Expected the profile to set the flags, got %q, %v, %v", *promptProfile, *maxCost, contextFiles)
+	}
+}
+
+// TestPrintConfig tests that the effective configuration can be read back
+func TestPrintConfig(t *testing.T) {
+	topK := int32(20)
+	config := configuration{
+		GCPProject:  "p",
+		GCPRegion:   "europe-west9",
+		GeminiModel: "gemini-2.0-flash",
+		Synthesis:   phaseSettings{Model: "gemini-2.5-pro", Temperature: 0.4, TopK: &topK},
+	}
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.String("language", "French", "")
+	fs.Int("speakers", 3, "")
+	contextFiles := stringsFlag{"brief.md"}
+	fs.Var(&contextFiles, "context-file", "")
+
+	var buf bytes.Buffer
+	if err := printConfig(&buf, "audiotranscribe.yaml", "interviews", effectiveProfile(config, fs, priceTable{"m": {Input: 1}})); err != nil {
+		t.Fatalf("printConfig failed: %v", err)
+	}
+	path := writeTestConfig(t, buf.String())
+	_, profile, err := loadConfigProfile(path, "interviews")
+	if err != nil {
+		t.Fatalf("Expected the output to be a valid configuration file, got %v:\n%s", err, buf.String())
+	}
+	if profile.Synthesis.Model != "gemini-2.5-pro" || *profile.Synthesis.TopK != 20 || profile.Prompts.Speakers != 3 || profile.Prices["m"].Input != 1 {
+		t.Errorf("Unexpected profile read back:\n%s", buf.String())
+	}
+	if !strings.HasPrefix(buf.String(), "# Effective configuration (audiotranscribe.yaml, profile interviews;") {
+		t.Errorf("Unexpected header:\n%s", buf.String())
+	}
+}
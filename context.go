@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// contextPreamble introduces the project documents given to the model as
+// system instructions.
+const contextPreamble = `You are helping a team analyze the recordings of their study.
+The following documents describe the project (brief, glossary, research questions...).
+Use them to frame your analysis, but only report what is actually said in the transcripts.`
+
+// loadContext builds a system instruction out of the given context files.
+// It returns an empty string if there is no file.
+func loadContext(paths []string) (string, error) {
+	if len(paths) == 0 {
+		return "", nil
+	}
+	var b strings.Builder
+	b.WriteString(contextPreamble)
+	for _, path := range paths {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("unable to read context file: %w", err)
+		}
+		fmt.Fprintf(&b, "\n\n<document name=%q>\n%s\n</document>", filepath.Base(path), strings.TrimSpace(string(content)))
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestLoadContext tests that every context file ends up in the system instruction
+func TestLoadContext(t *testing.T) {
+	dir := t.TempDir()
+	brief := filepath.Join(dir, "brief.md")
+	glossary := filepath.Join(dir, "glossary.md")
+	os.WriteFile(brief, []byte("We are redesigning the checkout.\n"), 0o644)
+	os.WriteFile(glossary, []byte("PDP: product detail page\n"), 0o644)
+
+	instruction, err := loadContext([]string{brief, glossary})
+	if err != nil {
+		t.Fatalf("loadContext failed: %v", err)
+	}
+	for _, expected := range []string{
+		contextPreamble,
+		"<document name=\"brief.md\">\nWe are redesigning the checkout.\n</document>",
+		"<document name=\"glossary.md\">\nPDP: product detail page\n</document>",
+	} {
+		if !strings.Contains(instruction, expected) {
+			t.Errorf("Expected instruction to contain %q, got:\n%s", expected, instruction)
+		}
+	}
+}
+
+// TestLoadContextEmpty tests that no context file means no system instruction
+func TestLoadContextEmpty(t *testing.T) {
+	instruction, err := loadContext(nil)
+	if err != nil {
+		t.Fatalf("loadContext failed: %v", err)
+	}
+	if instruction != "" {
+		t.Errorf("Expected no instruction, got %q", instruction)
+	}
+	if _, err := loadContext([]string{filepath.Join(t.TempDir(), "missing.md")}); err == nil {
+		t.Error("Expected an error for a missing context file")
+	}
+}
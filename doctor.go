@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+
+	"cloud.google.com/go/vertexai/genai"
+	"github.com/kelseyhightower/envconfig"
+	"golang.org/x/oauth2/google"
+)
+
+func doctorCommand(cmd *command, args []string) error {
+	f := newCLIFlags(cmd, 0)
+	if err := f.parse(args); err != nil {
+		return err
+	}
+	return doctor(context.Background(), os.Stdout, f.cfgPath, f.cfgName)
+}
+
+// doctor checks everything a run depends on and writes the results to w.
+// It returns an error if a check failed.
+func doctor(ctx context.Context, w io.Writer, cfgPath, cfgName string) error {
+	failed := 0
+	check := func(name string, err error) bool {
+		if err != nil {
+			fmt.Fprintf(w, "FAIL  %s: %v\n", name, err)
+			failed++
+			return false
+		}
+		fmt.Fprintf(w, "ok    %s\n", name)
+		return true
+	}
+
+	switch {
+	case cfgPath == "":
+		check("configuration file: none, environment and flags only", nil)
+	default:
+		check(fmt.Sprintf("configuration file: %s, profile %q", cfgPath, cfgName), nil)
+	}
+
+	var config configuration
+	err := envconfig.Process("", &config)
+	if err == nil {
+		err = config.validate()
+	}
+	configured := check("configuration", err)
+
+	if _, err := exec.LookPath("ffprobe"); err != nil {
+		check("ffprobe", fmt.Errorf("%w: durations are estimated, -on-blocked=split is unavailable", err))
+	} else {
+		check("ffprobe", nil)
+	}
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		check("ffmpeg", fmt.Errorf("%w: split and -on-blocked=split are unavailable", err))
+	} else {
+		check("ffmpeg", nil)
+	}
+
+	_, err = google.FindDefaultCredentials(ctx, "https://www.googleapis.com/auth/cloud-platform")
+	if err != nil {
+		err = fmt.Errorf("%w: run gcloud auth application-default login", err)
+	}
+	authenticated := check("Google credentials", err)
+
+	if configured && authenticated {
+		client, err := genai.NewClient(ctx, config.GCPProject, config.GCPRegion)
+		if check(fmt.Sprintf("Vertex AI client (project %s, region %s)", config.GCPProject, config.GCPRegion), err) {
+			defer client.Close()
+			seen := make(map[string]bool)
+			for _, phase := range []string{phaseTranscription, phaseSynthesis} {
+				for _, model := range config.models(phase) {
+					if seen[model] {
+						continue
+					}
+					seen[model] = true
+					_, err := client.GenerativeModel(model).CountTokens(ctx, genai.Text("ping"))
+					check(fmt.Sprintf("model %s (%s)", model, phase), err)
+				}
+			}
+		}
+		if config.GCSBucket != "" {
+			check("bucket "+config.GCSBucket, bucketAccessible(ctx, config.GCSBucket))
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d check(s) failed", failed)
+	}
+	return nil
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"cloud.google.com/go/vertexai/genai"
+)
+
+const (
+	// audioTokensPerSecond is the number of tokens the model uses for a
+	// second of audio.
+	audioTokensPerSecond = 32
+	// transcriptTokensPerSecond estimates the size of a transcript, based on
+	// about 150 spoken words per minute.
+	transcriptTokensPerSecond = 4
+	// synthesisOutputTokens estimates the size of a synthesis or of a
+	// partial summary.
+	synthesisOutputTokens = 2000
+	// assumedBytesPerSecond estimates the duration of a file when ffprobe is
+	// not available (128 kbit/s).
+	assumedBytesPerSecond = 16000
+	// defaultChunkDuration is the duration of the chunks made by
+	// split_and_transcribe.sh.
+	defaultChunkDuration = 25 * time.Minute
+)
+
+// fileEstimate is the planned transcription of an audio file.
+type fileEstimate struct {
+	Path              string
+	Size              int64
+	Duration          time.Duration
+	DurationEstimated bool
+	Chunks            int
+	Route             string
+	Usage             tokenUsage
+	// Counted is true if the input tokens come from the CountTokens API
+	// rather than from the duration.
+	Counted bool
+	Cost    float64
+}
+
+// runEstimate is the planned usage of a run.
+type runEstimate struct {
+	// TranscriptionModel and SynthesisModel are the first models of the
+	// chains of the phases.
+	TranscriptionModel string
+	SynthesisModel     string
+	Files              []fileEstimate
+	SynthesisRequests  int
+	SynthesisUsage     tokenUsage
+	SynthesisCost      float64
+}
+
+// estimateRun plans the run without transcribing anything. The input tokens
+// are counted with the CountTokens API for the files sent inline, unless
+// offline is set; otherwise they are estimated from the duration.
+func estimateRun(ctx context.Context, transcriber, synthesizer generation, opts options, filePaths []string, chunkDuration time.Duration, offline bool) (runEstimate, error) {
+	gen := transcriber
+	est := runEstimate{TranscriptionModel: transcriber.model, SynthesisModel: synthesizer.model}
+
+	var model *genai.GenerativeModel
+	if !offline {
+		client, err := genai.NewClient(ctx, gen.projectID, gen.location)
+		if err != nil {
+			logger.Warn("unable to create client, estimating tokens offline", "error", err)
+		} else {
+			defer client.Close()
+			model = gen.newModel(client)
+		}
+	}
+
+	transcriptionPrompt := opts.prompts.Transcription + opts.glossary.hint()
+	var transcriptTokens int32
+	for _, path := range filePaths {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return est, fmt.Errorf("failed to read audio file: %w", err)
+		}
+		f := fileEstimate{Path: path, Size: fi.Size(), Chunks: 1}
+
+		f.Duration, err = probeDuration(ctx, path)
+		if err != nil {
+			logger.Warn("unable to get audio duration, estimating it from the size", "file", path, "error", err)
+			f.Duration = estimateDuration(fi.Size())
+			f.DurationEstimated = true
+		}
+		if chunkDuration > 0 && f.Duration > chunkDuration {
+			f.Chunks = int(math.Ceil(float64(f.Duration) / float64(chunkDuration)))
+		}
+		f.Route = routeAudio(f.Size/int64(f.Chunks), gen.bucket)
+
+		f.Usage = estimateTranscription(f.Duration, transcriptionPrompt, f.Chunks)
+		// Only a file sent as it is can be counted: a chunk is not a file
+		// yet, and the whole file may be above the inline limit.
+		if model != nil && f.Route == routeInline && f.Chunks == 1 {
+			if n, err := countAudioTokens(ctx, model, path, transcriptionPrompt); err != nil {
+				logger.Warn("unable to count tokens, using an estimate", "file", path, "error", err)
+			} else {
+				f.Usage.Prompt = n
+				f.Usage.Total = f.Usage.Prompt + f.Usage.Candidates
+				f.Counted = true
+			}
+		}
+		f.Cost = opts.prices.cost(gen.model, phaseTranscription, f.Usage)
+
+		transcriptTokens += f.Usage.Candidates
+		est.Files = append(est.Files, f)
+	}
+
+	// The synthesis is hierarchical when the transcripts are too large: one
+	// partial summary per transcript, then the final synthesis.
+	summaryPromptTokens := int32(len(opts.prompts.Summary) / 4)
+	est.SynthesisRequests = 1
+	est.SynthesisUsage.Prompt = transcriptTokens + summaryPromptTokens
+	est.SynthesisUsage.Candidates = synthesisOutputTokens
+	if est.SynthesisUsage.Prompt > opts.summaryTokenLimit {
+		n := int32(len(filePaths))
+		est.SynthesisRequests += int(n)
+		est.SynthesisUsage.Prompt += n*(synthesisOutputTokens+int32(len(partialSummaryPrompt)/4)) + summaryPromptTokens
+		est.SynthesisUsage.Candidates += n * synthesisOutputTokens
+	}
+	// The quotes are extracted from the synthesis and the transcripts, one
+	// transcript at a time when they are too large.
+	if opts.quotes != "" && !opts.structured {
+		n := int32(1)
+		if transcriptTokens > opts.summaryTokenLimit {
+			n = int32(len(filePaths))
+		}
+		est.SynthesisRequests += int(n)
+		est.SynthesisUsage.Prompt += transcriptTokens + n*(synthesisOutputTokens+int32(len(quotesPrompt)/4))
+		est.SynthesisUsage.Candidates += n * synthesisOutputTokens
+	}
+	if opts.themes {
+		n := int32(1)
+		if transcriptTokens > opts.summaryTokenLimit {
+			n = int32(len(filePaths))
+		}
+		est.SynthesisRequests += int(n)
+		est.SynthesisUsage.Prompt += transcriptTokens + n*(synthesisOutputTokens+int32(len(themesPrompt)/4))
+		est.SynthesisUsage.Candidates += n * synthesisOutputTokens
+	}
+	// The personas are drafted from all the transcripts in a single call.
+	if opts.personas {
+		if transcriptTokens > opts.summaryTokenLimit {
+			logger.Warn("the transcripts exceed -summary-token-limit, the personas would not be drafted", "tokens", transcriptTokens, "limit", opts.summaryTokenLimit)
+		}
+		est.SynthesisRequests++
+		est.SynthesisUsage.Prompt += transcriptTokens + synthesisOutputTokens + int32(len(opts.prompts.Personas+personasNote)/4)
+		est.SynthesisUsage.Candidates += synthesisOutputTokens
+	}
+	if opts.minutes {
+		n := int32(1)
+		if transcriptTokens > opts.summaryTokenLimit {
+			n = int32(len(filePaths))
+		}
+		est.SynthesisRequests += int(n)
+		est.SynthesisUsage.Prompt += transcriptTokens + n*int32(len(minutesPrompt)/4)
+		est.SynthesisUsage.Candidates += n * synthesisOutputTokens
+	}
+	// The guide coverage is checked file by file.
+	if len(opts.guide) > 0 {
+		n := int32(len(filePaths))
+		est.SynthesisRequests += int(n)
+		est.SynthesisUsage.Prompt += transcriptTokens + n*int32(len(guidePrompt)/4+len(opts.guide)*20)
+		est.SynthesisUsage.Candidates += n * int32(len(opts.guide)) * 100
+	}
+	if opts.grounding {
+		n := int32(1)
+		if transcriptTokens > opts.summaryTokenLimit {
+			n = int32(len(filePaths))
+		}
+		est.SynthesisRequests += int(n)
+		est.SynthesisUsage.Prompt += transcriptTokens + n*(synthesisOutputTokens+int32(len(groundingPrompt)/4))
+		est.SynthesisUsage.Candidates += n * synthesisOutputTokens
+	}
+	est.SynthesisUsage.Total = est.SynthesisUsage.Prompt + est.SynthesisUsage.Candidates
+	est.SynthesisCost = opts.prices.cost(synthesizer.model, phaseSynthesis, est.SynthesisUsage)
+	return est, nil
+}
+
+// estimateDuration estimates the duration of an audio file from its size.
+func estimateDuration(size int64) time.Duration {
+	return time.Duration(size/assumedBytesPerSecond) * time.Second
+}
+
+// estimateTranscription estimates the usage of the transcription of an audio
+// file of the given duration, made in chunks calls with prompt.
+func estimateTranscription(duration time.Duration, prompt string, chunks int) tokenUsage {
+	seconds := duration.Seconds()
+	u := tokenUsage{
+		Prompt:     int32(seconds*audioTokensPerSecond) + int32(chunks)*int32(len(prompt)/4),
+		Candidates: int32(seconds * transcriptTokensPerSecond),
+	}
+	u.Total = u.Prompt + u.Candidates
+	return u
+}
+
+// countAudioTokens counts the tokens of the transcription request. The file
+// is sent inline, so it must be below maxInlineSize.
+func countAudioTokens(ctx context.Context, model *genai.GenerativeModel, audioFilePath, prompt string) (int32, error) {
+	audio, cleanup, err := audioPart(ctx, "", audioFilePath)
+	if err != nil {
+		return 0, err
+	}
+	defer cleanup()
+	res, err := model.CountTokens(ctx, audio, genai.Text(prompt))
+	if err != nil {
+		return 0, fmt.Errorf("unable to count tokens: %w", err)
+	}
+	return res.TotalTokens, nil
+}
+
+// requests returns the number of calls planned.
+func (e runEstimate) requests() int {
+	n := e.SynthesisRequests
+	for _, f := range e.Files {
+		n += f.Chunks
+	}
+	return n
+}
+
+// total returns the planned usage and cost of the run.
+func (e runEstimate) total() (tokenUsage, float64) {
+	usage, cost := e.SynthesisUsage, e.SynthesisCost
+	for _, f := range e.Files {
+		usage.add(f.Usage)
+		cost += f.Cost
+	}
+	return usage, cost
+}
+
+func (e runEstimate) print(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Dry run, nothing is transcribed. Transcription with %s, synthesis with %s.\n\n", e.TranscriptionModel, e.SynthesisModel)
+	fmt.Fprintln(tw, "FILE\tDURATION\tSIZE\tCHUNKS\tROUTING\tINPUT TOKENS\tOUTPUT TOKENS\tCOST (USD)\t")
+	var duration time.Duration
+	for _, f := range e.Files {
+		approx, source := "", "estimated"
+		if f.DurationEstimated {
+			approx = "~"
+		}
+		if f.Counted {
+			source = "counted"
+		}
+		fmt.Fprintf(tw, "%s\t%s%s\t%.1f MB\t%d\t%s\t%d (%s)\t~%d\t%.4f\t\n",
+			filepath.Base(f.Path), approx, f.Duration, float64(f.Size)/(1<<20), f.Chunks, f.Route, f.Usage.Prompt, source, f.Usage.Candidates, f.Cost)
+		duration += f.Duration
+	}
+	fmt.Fprintf(tw, "synthesis\t-\t-\t%d\t-\t~%d\t~%d\t%.4f\t\n",
+		e.SynthesisRequests, e.SynthesisUsage.Prompt, e.SynthesisUsage.Candidates, e.SynthesisCost)
+	usage, cost := e.total()
+	fmt.Fprintf(tw, "TOTAL\t%s\t-\t%d requests\t-\t%d\t%d\t%.4f\t\n", duration, e.requests(), usage.Prompt, usage.Candidates, cost)
+	return tw.Flush()
+}
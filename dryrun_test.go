@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestEstimateRunOffline tests the planning of a run from the size of the files
+func TestEstimateRunOffline(t *testing.T) {
+	if _, err := probeDuration(context.Background(), os.DevNull); err == nil {
+		t.Skip("ffprobe is available, durations would not be estimated from the size")
+	}
+	dir := t.TempDir()
+	short := filepath.Join(dir, "short.m4a")
+	long := filepath.Join(dir, "long.m4a")
+	// 100 seconds and 3000 seconds at the assumed bitrate.
+	os.WriteFile(short, make([]byte, 100*assumedBytesPerSecond), 0o644)
+	os.WriteFile(long, make([]byte, 3000*assumedBytesPerSecond), 0o644)
+
+	opts := options{
+		prompts:           prompts{Transcription: strings.Repeat("x", 400), Summary: strings.Repeat("y", 400)},
+		prices:            priceTable{"m": {Input: 1, AudioInput: 1, Output: 1}},
+		summaryTokenLimit: 500000,
+	}
+	est, err := estimateRun(context.Background(), generation{model: "m", bucket: "b"}, generation{model: "m"}, opts, []string{short, long}, defaultChunkDuration, true)
+	if err != nil {
+		t.Fatalf("estimateRun failed: %v", err)
+	}
+
+	if len(est.Files) != 2 {
+		t.Fatalf("Expected 2 files, got %d", len(est.Files))
+	}
+	s, l := est.Files[0], est.Files[1]
+	if s.Duration != 100*time.Second || !s.DurationEstimated || s.Chunks != 1 {
+		t.Errorf("Unexpected estimate for the short file: %+v", s)
+	}
+	if s.Usage.Prompt != 100*audioTokensPerSecond+100 || s.Usage.Candidates != 100*transcriptTokensPerSecond {
+		t.Errorf("Unexpected usage for the short file: %+v", s.Usage)
+	}
+	// 24 MB per chunk is above the inline limit.
+	if l.Chunks != 2 || l.Route != routeGCS {
+		t.Errorf("Expected the long file to be split in 2 chunks sent through Cloud Storage, got %+v", l)
+	}
+	if est.requests() != 1+2+1 {
+		t.Errorf("Expected 4 requests, got %d", est.requests())
+	}
+	usage, cost := est.total()
+	if cost <= 0 || usage.Total <= 0 {
+		t.Errorf("Expected a usage and a cost, got %+v and %v", usage, cost)
+	}
+
+	var buf bytes.Buffer
+	if err := est.print(&buf); err != nil {
+		t.Fatalf("print failed: %v", err)
+	}
+	if !strings.Contains(buf.String(), "4 requests") {
+		t.Errorf("Expected the number of requests in the output, got:\n%s", buf.String())
+	}
+}
+
+// TestEstimateRunRoute tests that a file is routed as a whole unless a chunk duration is given
+func TestEstimateRunRoute(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "large.m4a")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("failed to create audio file: %v", err)
+	}
+	if err := f.Truncate(3 * maxInlineSize); err != nil {
+		t.Fatalf("failed to grow audio file: %v", err)
+	}
+	f.Close()
+
+	est, err := estimateRun(context.Background(), generation{model: "m"}, generation{model: "m"}, options{summaryTokenLimit: 500000}, []string{path}, 0, true)
+	if err != nil {
+		t.Fatalf("estimateRun failed: %v", err)
+	}
+	if got := est.Files[0]; got.Chunks != 1 || got.Route != routeTooLarge {
+		t.Errorf("Expected the file to be sent as a single request too large to be inline, got %+v", got)
+	}
+	if est.requests() != 2 {
+		t.Errorf("Expected a transcription and a synthesis, got %d requests", est.requests())
+	}
+}
+
+// TestEstimateRunMapReduce tests that large inputs plan a hierarchical synthesis
+func TestEstimateRunMapReduce(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.m4a")
+	os.WriteFile(path, make([]byte, 100*assumedBytesPerSecond), 0o644)
+
+	opts := options{summaryTokenLimit: 10}
+	est, err := estimateRun(context.Background(), generation{model: "m"}, generation{model: "m"}, opts, []string{path, path}, 0, true)
+	if err != nil {
+		t.Fatalf("estimateRun failed: %v", err)
+	}
+	if est.SynthesisRequests != 3 {
+		t.Errorf("Expected 2 partial summaries and a synthesis, got %d requests", est.SynthesisRequests)
+	}
+}
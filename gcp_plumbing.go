@@ -87,3 +87,18 @@ func deleteObject(ctx context.Context, bucketName, objectName string) error {
 
 	return nil
 }
+
+// bucketAccessible checks that the bucket exists and can be read.
+func bucketAccessible(ctx context.Context, bucketName string) error {
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		return fmt.Errorf("storage.NewClient: %w", err)
+	}
+	defer client.Close()
+
+	if _, err := client.Bucket(bucketName).Attrs(ctx); err != nil {
+		return fmt.Errorf("Bucket(%q).Attrs: %w", bucketName, err)
+	}
+
+	return nil
+}
@@ -9,81 +9,384 @@ import (
 	"mime"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"cloud.google.com/go/vertexai/genai"
+	"google.golang.org/api/iterator"
 )
 
-func postProcess(input string, w io.Writer, projectID, location, modelName string) error {
+// generation holds the settings shared by the calls to the model.
+type generation struct {
+	projectID string
+	location  string
+	model     string
+	// settings are the generation settings of the phase.
+	settings phaseSettings
+	// fallbacks are the models tried in turn when model is unavailable or
+	// not supported, see withFallback.
+	fallbacks []string
+	// safetySettings are passed to the model; the defaults of the API apply
+	// when nil.
+	safetySettings []*genai.SafetySetting
+	// stream writes the responses to the output as they are generated.
+	stream bool
+	// ledger records the usage of the calls.
+	ledger *ledger
+	// bucket is the Cloud Storage bucket used for the audio files too large
+	// to be sent inline, if any.
+	bucket string
+}
+
+// record adds the usage of a call made with the generation model to the ledger.
+func (g generation) record(phase, file, chunk string, u tokenUsage) {
+	g.ledger.record(phase, file, chunk, g.model, u)
+}
+
+// check returns an error wrapping errBudget if a call to the generation
+// model with the estimated usage would exceed the budget of the run.
+func (g generation) check(phase string, estimate tokenUsage) error {
+	return g.ledger.check(phase, g.model, estimate)
+}
+
+// checker returns the budget check of the calls of phase, for generateText.
+func (g generation) checker(phase string) func(tokenUsage) error {
+	return func(estimate tokenUsage) error {
+		return g.check(phase, estimate)
+	}
+}
+
+// newModel returns the model configured with the generation settings.
+func (g generation) newModel(client *genai.Client) *genai.GenerativeModel {
+	model := client.GenerativeModel(g.model)
+	g.settings.apply(model)
+	model.SafetySettings = g.safetySettings
+	return model
+}
+
+// postProcess generates the synthesis of the transcripts, writes it to w and
+// returns it along with the token usage of the calls and the model that
+// produced it. If systemInstruction is
+// not empty, it is given to the model to frame the synthesis. When the prompt
+// and the transcripts exceed tokenLimit tokens, the transcripts are
+// summarized individually first (see summarizer). If schema is not nil, the
+// synthesis is a JSON document following it.
+func postProcess(transcripts []string, w io.Writer, gen generation, prompt, systemInstruction string, tokenLimit int32, schema *genai.Schema) (string, tokenUsage, string, error) {
 	ctx := context.Background()
 
-	client, err := genai.NewClient(ctx, projectID, location)
+	client, err := genai.NewClient(ctx, gen.projectID, gen.location)
 	if err != nil {
-		return fmt.Errorf("unable to create client: %w", err)
+		return "", tokenUsage{}, "", fmt.Errorf("unable to create client: %w", err)
 	}
 	defer client.Close()
 
-	model := client.GenerativeModel(modelName)
+	s := &summarizer{
+		client:            client,
+		systemInstruction: systemInstruction,
+		prompt:            prompt,
+		tokenLimit:        tokenLimit,
+		w:                 w,
+		gen:               gen,
+		schema:            schema,
+	}
+	synthesis, err := s.summarize(ctx, transcripts)
+	if err != nil {
+		return "", s.usage, s.gen.model, err
+	}
+	return synthesis, s.usage, s.gen.model, nil
+}
+
+const (
+	// maxContinuations is the number of times the model is asked to continue
+	// a response truncated by the output token limit.
+	maxContinuations = 5
+
+	continuePrompt = "Your previous answer was cut off by the output token limit. Continue exactly where you stopped, without repeating anything and without any introduction."
+)
+
+var errTruncated = errors.New("response truncated by the output token limit")
 
-	// Optional: set an explicit temperature
-	model.SetTemperature(0.4)
-	res, err := model.GenerateContent(ctx, genai.Text(SummaryPrompt), genai.Text(input))
+// generateText sends the parts to the model and returns the text of the
+// response along with the token usage of the calls. If the response is
+// truncated by the output token limit, the model is asked to continue and the
+// continuations are stitched to the response. An error wrapping errTruncated
+// is returned if the response is still incomplete after maxContinuations.
+//
+// Every continuation resends the parts and the text so far. If check is not
+// nil, it is called before each continuation with the usage of the calls
+// made so far plus the estimate of the continuation, and its error stops the
+// generation: the usage of the calls is not recorded in the ledger until
+// generateText returns.
+//
+// If stream is not nil, the responses are generated with the streaming API
+// and written to stream as they arrive. Repetitions at the beginning of a
+// continuation are then only removed from the returned text.
+func generateText(ctx context.Context, model *genai.GenerativeModel, stream io.Writer, check func(tokenUsage) error, parts ...genai.Part) (string, tokenUsage, error) {
+	res, err := send(ctx, stream, model.GenerateContent, model.GenerateContentStream, parts...)
+	if err != nil {
+		return "", tokenUsage{}, fmt.Errorf("unable to generate contents: %w", err)
+	}
+	text, finish, usage, err := responseText(res)
 	if err != nil {
-		return fmt.Errorf("unable to generate contents: %w", err)
+		return "", usage, err
 	}
+	first := usage
 
-	if len(res.Candidates) == 0 ||
-		len(res.Candidates[0].Content.Parts) == 0 {
-		return errors.New("empty response from model")
+	cs := model.StartChat()
+	for i := 1; finish == genai.FinishReasonMaxTokens; i++ {
+		if i > maxContinuations {
+			return text, usage, fmt.Errorf("%w: still incomplete after %d continuations", errTruncated, maxContinuations)
+		}
+		if check != nil {
+			// The continuation reads the parts and the text so far, and
+			// writes about as much as the first response.
+			next := tokenUsage{Prompt: first.Prompt + usage.Candidates, Candidates: first.Candidates}
+			next.Total = next.Prompt + next.Candidates
+			estimate := usage
+			estimate.add(next)
+			if err := check(estimate); err != nil {
+				return text, usage, err
+			}
+		}
+		logger.Warn("response truncated, asking the model to continue", "continuation", i, "length", len(text))
+
+		cs.History = []*genai.Content{
+			{Role: "user", Parts: parts},
+			{Role: "model", Parts: []genai.Part{genai.Text(text)}},
+		}
+		res, err := send(ctx, stream, cs.SendMessage, cs.SendMessageStream, genai.Text(continuePrompt))
+		if err != nil {
+			return text, usage, fmt.Errorf("%w: unable to continue: %w", errTruncated, err)
+		}
+		var (
+			more string
+			u    tokenUsage
+		)
+		more, finish, u, err = responseText(res)
+		usage.add(u)
+		if err != nil {
+			return text, usage, fmt.Errorf("%w: unable to continue: %w", errTruncated, err)
+		}
+		if strings.TrimSpace(more) == "" {
+			return text, usage, fmt.Errorf("%w: the model returned an empty continuation", errTruncated)
+		}
+		text = stitch(text, more)
+	}
+
+	return text, usage, nil
+}
+
+// send runs the request with generate, or with streamer if w is not nil.
+func send(ctx context.Context, w io.Writer,
+	generate func(context.Context, ...genai.Part) (*genai.GenerateContentResponse, error),
+	streamer func(context.Context, ...genai.Part) *genai.GenerateContentResponseIterator,
+	parts ...genai.Part) (*genai.GenerateContentResponse, error) {
+	if w == nil {
+		res, err := generate(ctx, parts...)
+		if err != nil {
+			return nil, asBlockedError(err)
+		}
+		return res, nil
 	}
-	logger.Info("Usage Metadata", "Prompt Token", res.UsageMetadata.PromptTokenCount, "Candidates Token", res.UsageMetadata.CandidatesTokenCount, "Total Token", res.UsageMetadata.TotalTokenCount)
-	logger.Info("Finish", "Finished Reason", res.Candidates[0].FinishReason, "Finish Message", res.Candidates[0].FinishMessage)
+	return streamResponse(streamer(ctx, parts...), w)
+}
 
-	fmt.Fprintf(w, "\n\nSynthesis:\n%s\n", res.Candidates[0].Content.Parts[0])
+// streamResponse writes the text of the responses to w, flushing it after
+// every response, and returns the merged response.
+func streamResponse(iter *genai.GenerateContentResponseIterator, w io.Writer) (*genai.GenerateContentResponse, error) {
+	var usage *genai.UsageMetadata
+	for {
+		res, err := iter.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return nil, asBlockedError(err)
+		}
+		// The usage of the last response covers the whole generation.
+		if res.UsageMetadata != nil {
+			usage = res.UsageMetadata
+		}
+		if len(res.Candidates) == 0 || res.Candidates[0].Content == nil {
+			continue
+		}
+		for _, part := range res.Candidates[0].Content.Parts {
+			if text, ok := part.(genai.Text); ok {
+				if _, err := io.WriteString(w, string(text)); err != nil {
+					return nil, fmt.Errorf("failed to write response: %w", err)
+				}
+			}
+		}
+		if err := flush(w); err != nil {
+			return nil, err
+		}
+	}
+
+	merged := iter.MergedResponse()
+	if merged == nil {
+		return nil, errors.New("empty response from model")
+	}
+	merged.UsageMetadata = usage
+	return merged, nil
+}
+
+// flush flushes w if it is a buffered writer.
+func flush(w io.Writer) error {
+	if bw, ok := w.(*bufio.Writer); ok {
+		if err := bw.Flush(); err != nil {
+			return fmt.Errorf("failed to flush output: %w", err)
+		}
+	}
 	return nil
 }
 
-// transcribeAudio transcribes an audio file and returns the transcript text
-func transcribeAudio(w io.Writer, projectID, location, modelName, audioFilePath string) (string, error) {
-	ctx := context.Background()
+// responseText concatenates the text parts of the first candidate of res.
+// It returns a *blockedError if the candidate was blocked.
+func responseText(res *genai.GenerateContentResponse) (string, genai.FinishReason, tokenUsage, error) {
+	usage := usageFrom(res.UsageMetadata)
+	if res.PromptFeedback != nil && res.PromptFeedback.BlockReason != genai.BlockedReasonUnspecified {
+		return "", 0, usage, &blockedError{
+			Prompt:  true,
+			Reason:  res.PromptFeedback.BlockReason.String(),
+			Message: res.PromptFeedback.BlockReasonMessage,
+		}
+	}
+	if len(res.Candidates) > 0 && isBlocked(res.Candidates[0].FinishReason) {
+		return "", res.Candidates[0].FinishReason, usage, &blockedError{
+			Reason:  res.Candidates[0].FinishReason.String(),
+			Message: res.Candidates[0].FinishMessage,
+		}
+	}
+	if len(res.Candidates) == 0 || res.Candidates[0].Content == nil ||
+		len(res.Candidates[0].Content.Parts) == 0 {
+		return "", 0, usage, errors.New("empty response from model")
+	}
+	candidate := res.Candidates[0]
+	logger.Info("Usage Metadata", "Prompt Token", usage.Prompt, "Candidates Token", usage.Candidates, "Total Token", usage.Total)
+	logger.Info("Finish", "Finished Reason", candidate.FinishReason, "Finish Message", candidate.FinishMessage, "Parts", len(candidate.Content.Parts))
 
-	client, err := genai.NewClient(ctx, projectID, location)
-	if err != nil {
-		return "", fmt.Errorf("unable to create client: %w", err)
+	var b strings.Builder
+	for _, part := range candidate.Content.Parts {
+		if text, ok := part.(genai.Text); ok {
+			b.WriteString(string(text))
+		} else {
+			logger.Warn("ignoring non-text part of the response", "type", fmt.Sprintf("%T", part))
+		}
 	}
-	defer client.Close()
+	return b.String(), candidate.FinishReason, usage, nil
+}
+
+// minOverlap is the minimum length of the text repeated at the beginning of a
+// continuation for it to be considered a repetition.
+const minOverlap = 20
+
+// stitch appends the continuation more to text, dropping the beginning of
+// more if the model repeated the end of text.
+func stitch(text, more string) string {
+	for k := min(len(text), len(more)); k >= minOverlap; k-- {
+		if strings.HasSuffix(text, more[:k]) {
+			return text + more[k:]
+		}
+	}
+	return text + more
+}
+
+// Routings of an audio file, see routeAudio.
+const (
+	routeInline   = "inline"
+	routeGCS      = "gcs"
+	routeTooLarge = "inline (too large, set GCS_BUCKET)"
+)
 
-	model := client.GenerativeModel(modelName)
+// maxInlineSize is the largest audio file sent inline in the request.
+const maxInlineSize = 20 << 20
 
-	// Optional: set an explicit temperature
-	model.SetTemperature(0.4)
+// routeAudio tells how an audio file of the given size is sent to the model.
+func routeAudio(size int64, bucket string) string {
+	switch {
+	case size <= maxInlineSize:
+		return routeInline
+	case bucket != "":
+		return routeGCS
+	default:
+		return routeTooLarge
+	}
+}
+
+// audioPart returns the part holding the audio file: the data itself, or a
+// reference to a copy uploaded to the bucket for large files. cleanup deletes
+// the uploaded copy.
+func audioPart(ctx context.Context, bucket, audioFilePath string) (genai.Part, func(), error) {
+	fi, err := os.Stat(audioFilePath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read audio file: %w", err)
+	}
+	mimeType := mime.TypeByExtension(filepath.Ext(audioFilePath))
+	route := routeAudio(fi.Size(), bucket)
+	logger.Info("Audio info", "mimetype", mimeType, "size", fi.Size(), "file", audioFilePath, "route", route)
+
+	switch route {
+	case routeGCS:
+		objectName := fmt.Sprintf("audiotranscribe/%d-%s", time.Now().UnixNano(), filepath.Base(audioFilePath))
+		if err := uploadAudioFile(ctx, bucket, objectName, audioFilePath); err != nil {
+			return nil, nil, fmt.Errorf("failed to upload audio file: %w", err)
+		}
+		cleanup := func() {
+			if err := deleteObject(context.Background(), bucket, objectName); err != nil {
+				logger.Warn("failed to delete uploaded audio file", "bucket", bucket, "object", objectName, "error", err)
+			}
+		}
+		return genai.FileData{
+			MIMEType: mimeType,
+			FileURI:  fmt.Sprintf("gs://%s/%s", bucket, objectName),
+		}, cleanup, nil
+	case routeTooLarge:
+		logger.Warn("audio file is larger than the inline limit, the request may fail", "file", audioFilePath, "size", fi.Size(), "limit", maxInlineSize)
+	}
 
 	// Read audio file into memory
 	audioData, err := os.ReadFile(audioFilePath)
 	if err != nil {
-		return "", fmt.Errorf("failed to read audio file: %w", err)
+		return nil, nil, fmt.Errorf("failed to read audio file: %w", err)
 	}
 
 	// Create Blob with audio data
-	audio := genai.Blob{
-		MIMEType: mime.TypeByExtension(filepath.Ext(audioFilePath)),
+	return genai.Blob{
+		MIMEType: mimeType,
 		Data:     audioData,
-	}
-	logger.Info("Audio info", "mimetype", audio.MIMEType, "size", len(audioData), "file", audioFilePath)
+	}, func() {}, nil
+}
+
+// transcribeAudio transcribes an audio file, writes the transcript to w as it
+// goes and returns the transcript text along with the token usage of the call.
+func transcribeAudio(w io.Writer, gen generation, prompt, audioFilePath string) (string, tokenUsage, error) {
+	ctx := context.Background()
 
-	res, err := model.GenerateContent(ctx, audio, genai.Text(TranscriptionPrompt))
+	client, err := genai.NewClient(ctx, gen.projectID, gen.location)
 	if err != nil {
-		return "", fmt.Errorf("unable to generate contents: %w", err)
+		return "", tokenUsage{}, fmt.Errorf("unable to create client: %w", err)
 	}
+	defer client.Close()
 
-	if len(res.Candidates) == 0 ||
-		len(res.Candidates[0].Content.Parts) == 0 {
-		return "", errors.New("empty response from model")
+	model := gen.newModel(client)
+
+	audio, cleanup, err := audioPart(ctx, gen.bucket, audioFilePath)
+	if err != nil {
+		return "", tokenUsage{}, err
 	}
-	logger.Info("Usage Metadata", "Prompt Token", res.UsageMetadata.PromptTokenCount, "Candidates Token", res.UsageMetadata.CandidatesTokenCount, "Total Token", res.UsageMetadata.TotalTokenCount)
-	logger.Info("Finish", "Finished Reason", res.Candidates[0].FinishReason, "Finish Message", res.Candidates[0].FinishMessage)
-	logger.Info("Response parts", "num_parts", len(res.Candidates[0].Content.Parts), "part_type", fmt.Sprintf("%T", res.Candidates[0].Content.Parts[0]))
+	defer cleanup()
 
-	transcriptText := string(res.Candidates[0].Content.Parts[0].(genai.Text))
+	var stream io.Writer
+	if gen.stream {
+		if _, err := fmt.Fprintf(w, "Generated transcript for %s:\n", audioFilePath); err != nil {
+			return "", tokenUsage{}, fmt.Errorf("failed to write transcript: %w", err)
+		}
+		stream = w
+	}
+	transcriptText, usage, err := generateText(ctx, model, stream, gen.checker(phaseTranscription), audio, genai.Text(prompt))
+	if err != nil {
+		return "", usage, err
+	}
 	logger.Info("Transcript length", "length", len(transcriptText), "file", audioFilePath)
 
 	// Check if transcript is empty
@@ -91,16 +394,19 @@ func transcribeAudio(w io.Writer, projectID, location, modelName, audioFilePath
 		logger.Warn("received empty transcript from Gemini", "file", audioFilePath)
 	}
 
-	if _, err := fmt.Fprintf(w, "Generated transcript for %s:\n%s\n\n", audioFilePath, transcriptText); err != nil {
-		return "", fmt.Errorf("failed to write transcript: %w", err)
+	if gen.stream {
+		_, err = io.WriteString(w, "\n\n")
+	} else {
+		_, err = fmt.Fprintf(w, "Generated transcript for %s:\n%s\n\n", audioFilePath, transcriptText)
+	}
+	if err != nil {
+		return "", tokenUsage{}, fmt.Errorf("failed to write transcript: %w", err)
 	}
 
 	// Flush the buffer if the writer is a buffered writer
-	if bw, ok := w.(*bufio.Writer); ok {
-		if err := bw.Flush(); err != nil {
-			return "", fmt.Errorf("failed to flush output: %w", err)
-		}
+	if err := flush(w); err != nil {
+		return "", tokenUsage{}, err
 	}
 
-	return transcriptText, nil
+	return transcriptText, usage, nil
 }
@@ -3,6 +3,7 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"os"
@@ -10,6 +11,8 @@ import (
 	"sync"
 	"testing"
 	"time"
+
+	"cloud.google.com/go/vertexai/genai"
 )
 
 // TestBufferedWriterFlushing tests if buffered writer properly flushes between writes
@@ -578,3 +581,130 @@ Speaker A: avait écrit en gros
 		t.Errorf("Output doesn't match expected pattern.\nExpected:\n%s\nGot:\n%s", expected, string(content))
 	}
 }
+
+// TestResponseTextMultiPart tests that every text part of the response is kept
+func TestResponseTextMultiPart(t *testing.T) {
+	res := &genai.GenerateContentResponse{
+		Candidates: []*genai.Candidate{{
+			Content: &genai.Content{Parts: []genai.Part{
+				genai.Text("Speaker A: first part. "),
+				genai.Text("Speaker B: second part."),
+			}},
+			FinishReason: genai.FinishReasonMaxTokens,
+		}},
+		UsageMetadata: &genai.UsageMetadata{PromptTokenCount: 10, CandidatesTokenCount: 5, TotalTokenCount: 15},
+	}
+
+	text, finish, usage, err := responseText(res)
+	if err != nil {
+		t.Fatalf("responseText failed: %v", err)
+	}
+	if text != "Speaker A: first part. Speaker B: second part." {
+		t.Errorf("Unexpected text: %q", text)
+	}
+	if finish != genai.FinishReasonMaxTokens {
+		t.Errorf("Expected finish reason %v, got %v", genai.FinishReasonMaxTokens, finish)
+	}
+	if usage.Total != 15 {
+		t.Errorf("Expected 15 tokens, got %d", usage.Total)
+	}
+}
+
+// TestResponseTextEmpty tests that a response without content is an error
+func TestResponseTextEmpty(t *testing.T) {
+	for name, res := range map[string]*genai.GenerateContentResponse{
+		"no candidate": {},
+		"no content":   {Candidates: []*genai.Candidate{{FinishReason: genai.FinishReasonStop}}},
+		"no part":      {Candidates: []*genai.Candidate{{Content: &genai.Content{}}}},
+	} {
+		if _, _, _, err := responseText(res); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+// TestStitch tests that the repetitions of a continuation are dropped
+func TestStitch(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		more     string
+		expected string
+	}{
+		{
+			name:     "no overlap",
+			text:     "Speaker A: we should ship",
+			more:     " the new checkout next week.",
+			expected: "Speaker A: we should ship the new checkout next week.",
+		},
+		{
+			name:     "repeated end",
+			text:     "Speaker A: we should ship the new checkout",
+			more:     "ship the new checkout next week.",
+			expected: "Speaker A: we should ship the new checkout next week.",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := stitch(tt.text, tt.more); got != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+// TestRouteAudio tests that only the files larger than the inline limit go through Cloud Storage
+func TestRouteAudio(t *testing.T) {
+	tests := []struct {
+		size     int64
+		bucket   string
+		expected string
+	}{
+		{size: 1 << 20, bucket: "", expected: routeInline},
+		{size: maxInlineSize, bucket: "bucket", expected: routeInline},
+		{size: maxInlineSize + 1, bucket: "bucket", expected: routeGCS},
+		{size: maxInlineSize + 1, bucket: "", expected: routeTooLarge},
+	}
+	for _, tt := range tests {
+		if got := routeAudio(tt.size, tt.bucket); got != tt.expected {
+			t.Errorf("routeAudio(%d, %q) = %q, expected %q", tt.size, tt.bucket, got, tt.expected)
+		}
+	}
+}
+
+// TestAudioPartInline tests that the files sent inline are read into a blob, whatever the size without a bucket
+func TestAudioPartInline(t *testing.T) {
+	dir := t.TempDir()
+	small := dir + "/small.mp3"
+	if err := os.WriteFile(small, []byte("audio"), 0o644); err != nil {
+		t.Fatalf("failed to write audio file: %v", err)
+	}
+	large := dir + "/large.mp3"
+	f, err := os.Create(large)
+	if err != nil {
+		t.Fatalf("failed to create audio file: %v", err)
+	}
+	if err := f.Truncate(maxInlineSize + 1); err != nil {
+		t.Fatalf("failed to grow audio file: %v", err)
+	}
+	f.Close()
+
+	for path, size := range map[string]int{small: 5, large: maxInlineSize + 1} {
+		part, cleanup, err := audioPart(context.Background(), "", path)
+		if err != nil {
+			t.Fatalf("audioPart(%s) failed: %v", path, err)
+		}
+		cleanup()
+		blob, ok := part.(genai.Blob)
+		if !ok {
+			t.Fatalf("Expected a blob for %s, got %T", path, part)
+		}
+		if len(blob.Data) != size || blob.MIMEType != "audio/mpeg" {
+			t.Errorf("Unexpected blob for %s: %d bytes of %q", path, len(blob.Data), blob.MIMEType)
+		}
+	}
+
+	if _, _, err := audioPart(context.Background(), "", dir+"/missing.mp3"); err == nil {
+		t.Error("Expected an error for a missing file")
+	}
+}
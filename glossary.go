@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// glossary is a list of terms (product names, acronyms, people...) that the
+// model tends to misspell.
+type glossary []string
+
+// loadGlossary reads a glossary file: one term per line, blank lines and
+// lines starting with # are ignored.
+func loadGlossary(path string) (glossary, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("os.Open: %w", err)
+	}
+	defer f.Close()
+
+	var g glossary
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		term := strings.Join(strings.Fields(scanner.Text()), " ")
+		if term == "" || strings.HasPrefix(term, "#") {
+			continue
+		}
+		g = append(g, term)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read glossary: %w", err)
+	}
+	return g, nil
+}
+
+// hint returns the vocabulary list to add to the transcription prompt.
+func (g glossary) hint() string {
+	if len(g) == 0 {
+		return ""
+	}
+	return "\n\nThe recording may mention the following names, products and acronyms. Use exactly this spelling when they are spoken:\n- " +
+		strings.Join(g, "\n- ")
+}
+
+// correction is a substitution made by the glossary on a transcript.
+type correction struct {
+	File  string
+	From  string
+	To    string
+	Count int
+}
+
+var wordRegexp = regexp.MustCompile(`[\p{L}\p{N}][\p{L}\p{N}'’-]*`)
+
+// correct replaces the near-misses of the glossary terms in text. A sequence
+// of words is a near-miss of a term if it has the same number of words, the
+// same first letter, and an edit distance (case insensitive) below
+// maxDistance. The case of a term shorter than 8 characters is only fixed
+// when the word is neither in lower case nor capitalized, since those are
+// ordinary words: "slack off" or "It was" are not Slack or IT, "SLACK" is.
+// The result is deterministic: longer terms are tried first and ties are
+// resolved by glossary order.
+func (g glossary) correct(file, text string) (string, []correction) {
+	if len(g) == 0 {
+		return text, nil
+	}
+	type candidate struct {
+		term  string
+		lower string
+		words int
+	}
+	candidates := make([]candidate, len(g))
+	for i, term := range g {
+		candidates[i] = candidate{term: term, lower: strings.ToLower(term), words: len(strings.Fields(term))}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].words != candidates[j].words {
+			return candidates[i].words > candidates[j].words
+		}
+		return len(candidates[i].term) > len(candidates[j].term)
+	})
+
+	words := wordRegexp.FindAllStringIndex(text, -1)
+	var (
+		b           strings.Builder
+		corrections []correction
+		last        int
+	)
+	for i := 0; i < len(words); {
+		matched := 0
+		for _, c := range candidates {
+			if i+c.words > len(words) {
+				continue
+			}
+			start, end := words[i][0], words[i+c.words-1][1]
+			found := text[start:end]
+			lower := strings.ToLower(strings.Join(strings.Fields(found), " "))
+			if !sameFirstRune(lower, c.lower) {
+				continue
+			}
+			distance := levenshtein(lower, c.lower)
+			if distance > maxDistance(c.lower) {
+				continue
+			}
+			if distance == 0 && utf8.RuneCountInString(c.lower) < 8 && (found == lower || found == capitalize(lower)) {
+				continue
+			}
+			if found != c.term {
+				b.WriteString(text[last:start])
+				b.WriteString(c.term)
+				last = end
+				corrections = addCorrection(corrections, file, found, c.term)
+			}
+			matched = c.words
+			break
+		}
+		if matched == 0 {
+			matched = 1
+		}
+		i += matched
+	}
+	if len(corrections) == 0 {
+		return text, nil
+	}
+	b.WriteString(text[last:])
+	return b.String(), corrections
+}
+
+func addCorrection(corrections []correction, file, from, to string) []correction {
+	for i := range corrections {
+		if corrections[i].From == from && corrections[i].To == to {
+			corrections[i].Count++
+			return corrections
+		}
+	}
+	return append(corrections, correction{File: file, From: from, To: to, Count: 1})
+}
+
+// maxDistance is the number of edits tolerated for a term. Terms shorter
+// than 8 characters are only fixed for their case: a single edit turns too
+// many ordinary words into them, such as stack into Slack or nation into
+// Notion.
+func maxDistance(term string) int {
+	switch n := utf8.RuneCountInString(term); {
+	case n < 8:
+		return 0
+	case n < 12:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// capitalize returns s with its first letter in upper case.
+func capitalize(s string) string {
+	r, n := utf8.DecodeRuneInString(s)
+	return string(unicode.ToUpper(r)) + s[n:]
+}
+
+func sameFirstRune(a, b string) bool {
+	ra, _ := utf8.DecodeRuneInString(a)
+	rb, _ := utf8.DecodeRuneInString(b)
+	return ra == rb
+}
+
+// levenshtein returns the edit distance between a and b, counted in runes.
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestLoadGlossary tests that comments and blank lines are skipped
+func TestLoadGlossary(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "terms.txt")
+	os.WriteFile(path, []byte("# products\nShopwise\n\n  Click   and Collect \nSKU\n"), 0o644)
+
+	g, err := loadGlossary(path)
+	if err != nil {
+		t.Fatalf("loadGlossary failed: %v", err)
+	}
+	expected := glossary{"Shopwise", "Click and Collect", "SKU"}
+	if !reflect.DeepEqual(g, expected) {
+		t.Errorf("Expected %q, got %q", expected, g)
+	}
+	if !strings.Contains(g.hint(), "\n- Click and Collect\n") {
+		t.Errorf("Expected hint to list the terms, got %q", g.hint())
+	}
+}
+
+// TestGlossaryCorrect tests the fuzzy correction of near-misses
+func TestGlossaryCorrect(t *testing.T) {
+	g := glossary{"Shopwise", "Click and Collect", "SKU", "Kubernetes"}
+	text := "Speaker A: On Shopwyse we added click and colect, every sKu is synced. Shopwyse runs on Kubernetis.\nSpeaker B: Shopwise is fine, the shop wise team agreed, kubernetis too."
+
+	got, corrections := g.correct("chunk_000.m4a", text)
+
+	expected := "Speaker A: On Shopwise we added Click and Collect, every SKU is synced. Shopwise runs on Kubernetes.\nSpeaker B: Shopwise is fine, the shop wise team agreed, Kubernetes too."
+	if got != expected {
+		t.Errorf("Correction mismatch.\nExpected: %s\nGot:      %s", expected, got)
+	}
+	expectedCorrections := []correction{
+		{File: "chunk_000.m4a", From: "Shopwyse", To: "Shopwise", Count: 2},
+		{File: "chunk_000.m4a", From: "click and colect", To: "Click and Collect", Count: 1},
+		{File: "chunk_000.m4a", From: "sKu", To: "SKU", Count: 1},
+		{File: "chunk_000.m4a", From: "Kubernetis", To: "Kubernetes", Count: 1},
+		{File: "chunk_000.m4a", From: "kubernetis", To: "Kubernetes", Count: 1},
+	}
+	if !reflect.DeepEqual(corrections, expectedCorrections) {
+		t.Errorf("Expected corrections %+v, got %+v", expectedCorrections, corrections)
+	}
+}
+
+// TestGlossaryCorrectShortTerms tests that short terms are not fuzzily matched
+func TestGlossaryCorrectShortTerms(t *testing.T) {
+	g := glossary{"SKU", "Acme"}
+	text := "The sky is blue and the acne is gone."
+	got, corrections := g.correct("a.m4a", text)
+	if got != text || corrections != nil {
+		t.Errorf("Expected no correction, got %q and %+v", got, corrections)
+	}
+}
+
+// TestGlossaryCorrectCommonWords tests that the ordinary words close to a term are kept
+func TestGlossaryCorrectCommonWords(t *testing.T) {
+	g := glossary{"Slack", "Notion", "IT", "US", "Figma", "Shopwise"}
+	text := "Speaker A: stack the boxes for the nation. We slack off, it is fine, the figure shows it. Then Shopwise, and Slack, Notion and IT.\nSpeaker B: flack and motion, a sigma. It was great. Us too."
+	got, corrections := g.correct("a.m4a", text)
+	if got != text || corrections != nil {
+		t.Errorf("Expected no correction, got %q and %+v", got, corrections)
+	}
+
+	// The case of a short term is only fixed when the word is neither in
+	// lower case nor capitalized.
+	got, _ = g.correct("a.m4a", "Speaker A: It is on NOTION and SLACK, not on figma.")
+	if got != "Speaker A: It is on Notion and Slack, not on figma." {
+		t.Errorf("Unexpected case correction: %q", got)
+	}
+}
+
+// TestLevenshtein tests the edit distance
+func TestLevenshtein(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		distance int
+	}{
+		{"", "", 0},
+		{"abc", "", 3},
+		{"kitten", "sitting", 3},
+		{"shopwyse", "shopwise", 1},
+		{"élan", "elan", 1},
+	}
+	for _, tt := range tests {
+		if got := levenshtein(tt.a, tt.b); got != tt.distance {
+			t.Errorf("levenshtein(%q, %q) = %d, expected %d", tt.a, tt.b, got, tt.distance)
+		}
+	}
+}
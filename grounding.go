@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"cloud.google.com/go/vertexai/genai"
+)
+
+// Grounding of a claim of the synthesis, from the best to the worst.
+const (
+	groundingSupported   = "supported"
+	groundingPartial     = "partial"
+	groundingUnsupported = "unsupported"
+)
+
+// groundingPrompt asks for the passages supporting the claims.
+const groundingPrompt = `Check every numbered claim below, taken from a synthesis of the transcripts below, against the transcripts.
+
+1. For every claim, give its number and whether the transcripts support it: supported, partial (only part of the claim, or a weaker version of it, is in the transcripts) or unsupported
+2. Give up to 3 passages supporting the claim, copied word for word from a single transcript, with the file of the transcript as in its <transcript file="..."> tag and the speaker as labelled in the transcript (an empty string if unknown)
+3. Give no passage for an unsupported claim; do not use the synthesis itself as evidence`
+
+// groundingSchema is the schema of the response to groundingPrompt.
+var groundingSchema = &genai.Schema{
+	Type: genai.TypeArray,
+	Items: &genai.Schema{
+		Type: genai.TypeObject,
+		Properties: map[string]*genai.Schema{
+			"claim":  {Type: genai.TypeInteger},
+			"status": {Type: genai.TypeString, Enum: []string{groundingSupported, groundingPartial, groundingUnsupported}},
+			"passages": {
+				Type: genai.TypeArray,
+				Items: &genai.Schema{
+					Type: genai.TypeObject,
+					Properties: map[string]*genai.Schema{
+						"file":    {Type: genai.TypeString},
+						"speaker": {Type: genai.TypeString},
+						"excerpt": {Type: genai.TypeString},
+					},
+					Required: []string{"file", "speaker", "excerpt"},
+				},
+			},
+		},
+		Required: []string{"claim", "status", "passages"},
+	},
+}
+
+// claim is a statement of the synthesis and the passages of the transcripts
+// supporting it.
+type claim struct {
+	Text     string
+	Status   string
+	Passages []passage
+}
+
+// passage is an excerpt of a transcript.
+type passage struct {
+	File    string `json:"file"`
+	Speaker string `json:"speaker"`
+	Excerpt string `json:"excerpt"`
+}
+
+// groundingResult is the check of a claim returned by the model; Claim is
+// its number, starting at 1.
+type groundingResult struct {
+	Claim    int       `json:"claim"`
+	Status   string    `json:"status"`
+	Passages []passage `json:"passages"`
+}
+
+// bulletRe matches the items of the markdown lists.
+var bulletRe = regexp.MustCompile(`^\s*(?:[-*+]|\d+[.)])\s+(.*)$`)
+
+// synthesisClaims returns the items of the lists of the synthesis, without
+// their markers. Bold and italic markers are kept.
+func synthesisClaims(synthesis string) []string {
+	var claims []string
+	for _, line := range strings.Split(synthesis, "\n") {
+		if m := bulletRe.FindStringSubmatch(line); m != nil {
+			if text := strings.TrimSpace(m[1]); text != "" {
+				claims = append(claims, text)
+			}
+		}
+	}
+	return claims
+}
+
+// checkGrounding asks the model for the passages of the sources supporting
+// every item of the lists of the synthesis, and writes the result to w.
+// When the transcripts exceed tokenLimit tokens, the claims are checked
+// against one transcript at a time and the results are merged.
+func checkGrounding(w io.Writer, gen generation, systemInstruction, synthesis string, sources []source, tokenLimit int32) ([]claim, error) {
+	var claims []claim
+	for _, text := range synthesisClaims(synthesis) {
+		claims = append(claims, claim{Text: text, Status: groundingUnsupported})
+	}
+	if len(claims) == 0 {
+		logger.Warn("no list item to check in the synthesis")
+		return claims, nil
+	}
+
+	ctx := context.Background()
+	client, err := genai.NewClient(ctx, gen.projectID, gen.location)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create client: %w", err)
+	}
+	defer client.Close()
+
+	var numbered strings.Builder
+	for i, c := range claims {
+		fmt.Fprintf(&numbered, "%d. %s\n", i+1, c.Text)
+	}
+	j := jsonGenerator{client: client, gen: gen, systemInstruction: systemInstruction, schema: groundingSchema, phase: phaseGrounding}
+	groups, tokens := j.groups(ctx, sources, tokenLimit, genai.Text(groundingPrompt), genai.Text(numbered.String()))
+
+	for _, group := range groups {
+		var results []groundingResult
+		estimate := tokens * int32(len(group)) / int32(len(sources))
+		if err := j.generate(ctx, estimate, &results, genai.Text(groundingPrompt), genai.Text("Claims:\n"+numbered.String()), genai.Text(formatCorpus(group))); err != nil {
+			return claims, err
+		}
+		for _, r := range results {
+			if r.Claim < 1 || r.Claim > len(claims) {
+				logger.Warn("ignoring the check of an unknown claim", "claim", r.Claim)
+				continue
+			}
+			c := &claims[r.Claim-1]
+			if groundingRank(r.Status) < groundingRank(c.Status) {
+				c.Status = r.Status
+			}
+			c.Passages = append(c.Passages, r.Passages...)
+		}
+	}
+
+	if _, err := io.WriteString(w, "\n\nGrounding:\n"); err != nil {
+		return claims, fmt.Errorf("failed to write grounding: %w", err)
+	}
+	for _, c := range claims {
+		fmt.Fprintf(w, "- [%s] %s (%d passages)\n", c.Status, c.Text, len(c.Passages))
+	}
+	return claims, flush(w)
+}
+
+// countUnsupported returns the number of unsupported claims.
+func countUnsupported(claims []claim) int {
+	n := 0
+	for _, c := range claims {
+		if c.Status == groundingUnsupported {
+			n++
+		}
+	}
+	return n
+}
+
+// groundingRank orders the grounding statuses, the best first.
+func groundingRank(status string) int {
+	switch status {
+	case groundingSupported:
+		return 0
+	case groundingPartial:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// verifyGrounding keeps the passages found in the transcripts, as
+// verifyQuotes does for the quotes. A claim left without passage is
+// unsupported.
+func verifyGrounding(claims []claim, sources []source) []claim {
+	for i := range claims {
+		c := &claims[i]
+		quotes := make([]quote, len(c.Passages))
+		for k, p := range c.Passages {
+			quotes[k] = quote{Text: p.Excerpt, File: p.File, Speaker: p.Speaker}
+		}
+		c.Passages = c.Passages[:0]
+		for _, q := range verifyQuotes(quotes, sources, quotesDrop) {
+			c.Passages = append(c.Passages, passage{File: q.File, Speaker: q.Speaker, Excerpt: q.Text})
+		}
+		if len(c.Passages) == 0 && c.Status != groundingUnsupported {
+			logger.Warn("no passage found for a claim", "claim", c.Text, "status", c.Status)
+			c.Status = groundingUnsupported
+		}
+	}
+	return claims
+}
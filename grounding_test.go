@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestSynthesisClaims tests that the items of the lists are the claims checked
+func TestSynthesisClaims(t *testing.T) {
+	synthesis := "## Key Takeaways\n\nIntro paragraph.\n\n- **Checkout** is too slow\n  * on mobile especially\n1. Card is the main payment method\n-\n"
+	expected := []string{"**Checkout** is too slow", "on mobile especially", "Card is the main payment method"}
+	if claims := synthesisClaims(synthesis); !reflect.DeepEqual(claims, expected) {
+		t.Errorf("synthesisClaims() = %q, expected %q", claims, expected)
+	}
+}
+
+// TestVerifyGrounding tests that the passages not found are removed and that a claim left without passage is unsupported
+func TestVerifyGrounding(t *testing.T) {
+	sources := []source{{Path: "/tmp/interview1.m4a", Transcript: "Speaker A: the checkout is way too slow on mobile"}}
+	claims := verifyGrounding([]claim{
+		{Text: "Checkout is slow", Status: groundingSupported, Passages: []passage{
+			{File: "interview1.m4a", Speaker: "Speaker A", Excerpt: "the checkout is way too slow"},
+			{File: "interview1.m4a", Speaker: "Speaker A", Excerpt: "I hate the new design"},
+		}},
+		{Text: "Users love the design", Status: groundingPartial, Passages: []passage{
+			{File: "interview1.m4a", Excerpt: "I love the design"},
+		}},
+	}, sources)
+
+	if claims[0].Status != groundingSupported || len(claims[0].Passages) != 1 {
+		t.Errorf("Expected the first claim to keep one passage, got %+v", claims[0])
+	}
+	if claims[1].Status != groundingUnsupported || len(claims[1].Passages) != 0 {
+		t.Errorf("Expected the second claim to be unsupported, got %+v", claims[1])
+	}
+}
+
+// TestDefaultReportTemplateGrounding tests the grounding appendix
+func TestDefaultReportTemplateGrounding(t *testing.T) {
+	tmpl, err := loadReportTemplate("")
+	if err != nil {
+		t.Fatalf("failed to load default template: %v", err)
+	}
+	rep := testReport()
+	rep.Grounding = []claim{
+		{Text: "one", Status: groundingSupported, Passages: []passage{{File: "chunk_000.m4a", Speaker: "Speaker A", Excerpt: "hello"}}},
+		{Text: "two", Status: groundingUnsupported},
+	}
+	var buf bytes.Buffer
+	if err := rep.render(&buf, tmpl); err != nil {
+		t.Fatalf("render failed: %v", err)
+	}
+	expected := "## Appendix: grounding of the synthesis\n\n- **supported**: one\n  - Speaker A, chunk_000.m4a: \"hello\"\n- **unsupported**: two\n"
+	if !strings.Contains(buf.String(), expected) {
+		t.Errorf("Expected report to contain %q, got:\n%s", expected, buf.String())
+	}
+}
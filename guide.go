@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"cloud.google.com/go/vertexai/genai"
+)
+
+// Coverage of a guide question by an interview.
+const (
+	coverageCovered = "covered"
+	coveragePartial = "partial"
+	coverageGap     = "gap"
+)
+
+// guidePrompt asks how an interview covered the questions of the guide.
+const guidePrompt = `Below are the numbered questions of the discussion guide of an interview, then the transcript of the interview. For every question of the guide:
+
+1. Give its number and whether the interview covered it: covered (the participant answered it), partial (it was touched on without a real answer) or gap (it was not discussed)
+2. Summarize the answer of the participant in one or two sentences, or leave it empty for a gap
+3. Give the quote that best answers the question, copied word for word from the transcript, with its speaker as labelled in the transcript (empty strings for a gap or if unknown)
+
+Write the summaries in the language of the interview.`
+
+// guideSchema is the schema of the response to guidePrompt.
+var guideSchema = &genai.Schema{
+	Type: genai.TypeArray,
+	Items: &genai.Schema{
+		Type: genai.TypeObject,
+		Properties: map[string]*genai.Schema{
+			"question": {Type: genai.TypeInteger},
+			"coverage": {Type: genai.TypeString, Enum: []string{coverageCovered, coveragePartial, coverageGap}},
+			"answer":   {Type: genai.TypeString},
+			"quote":    {Type: genai.TypeString},
+			"speaker":  {Type: genai.TypeString},
+		},
+		Required: []string{"question", "coverage", "answer", "quote", "speaker"},
+	},
+}
+
+// guideQuestion is a question of a discussion guide, numbered from 1.
+type guideQuestion struct {
+	Number  int    `json:"number"`
+	Section string `json:"section,omitempty"`
+	Text    string `json:"text"`
+}
+
+// loadGuide reads the questions of a discussion guide: the items of the
+// lists of a markdown file, or all its lines if it has no list. The headings
+// are the sections of the questions that follow them.
+func loadGuide(path string) ([]guideQuestion, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read guide: %w", err)
+	}
+	lines := strings.Split(string(content), "\n")
+	hasList := false
+	for _, line := range lines {
+		if bulletRe.MatchString(line) {
+			hasList = true
+			break
+		}
+	}
+
+	var questions []guideQuestion
+	section := ""
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if heading, ok := strings.CutPrefix(line, "#"); ok {
+			section = strings.TrimSpace(strings.TrimLeft(heading, "#"))
+			continue
+		}
+		text := line
+		if m := bulletRe.FindStringSubmatch(line); m != nil {
+			text = strings.TrimSpace(m[1])
+		} else if hasList {
+			continue
+		}
+		if text != "" {
+			questions = append(questions, guideQuestion{Number: len(questions) + 1, Section: section, Text: text})
+		}
+	}
+	if len(questions) == 0 {
+		return nil, errors.New("no question found in the guide")
+	}
+	return questions, nil
+}
+
+// coverageMatrix tells which questions of the guide every interview covered.
+type coverageMatrix struct {
+	// Files are the interviews, in the order of the answers.
+	Files     []string           `json:"files"`
+	Questions []questionCoverage `json:"questions"`
+}
+
+// questionCoverage is the coverage of a question by every interview.
+type questionCoverage struct {
+	guideQuestion
+	Answers []guideAnswer `json:"answers"`
+	// Gaps is the number of interviews in which the question was not
+	// discussed.
+	Gaps int `json:"gaps"`
+}
+
+// guideAnswer is the coverage of a question by an interview.
+type guideAnswer struct {
+	File     string `json:"file"`
+	Coverage string `json:"coverage"`
+	Answer   string `json:"answer,omitempty"`
+	Quote    string `json:"quote,omitempty"`
+	Speaker  string `json:"speaker,omitempty"`
+	// Verified is true if the quote was found in the transcript.
+	Verified bool `json:"verified"`
+}
+
+// guideResult is the coverage of a question returned by the model; Question
+// is its number.
+type guideResult struct {
+	Question int    `json:"question"`
+	Coverage string `json:"coverage"`
+	Answer   string `json:"answer"`
+	Quote    string `json:"quote"`
+	Speaker  string `json:"speaker"`
+}
+
+// Gapped returns the questions not discussed in at least one interview.
+func (m *coverageMatrix) Gapped() []questionCoverage {
+	var gapped []questionCoverage
+	for _, q := range m.Questions {
+		if q.Gaps > 0 {
+			gapped = append(gapped, q)
+		}
+	}
+	return gapped
+}
+
+// checkCoverage asks the model, for every source, how it covered the
+// questions of the guide, and writes the result to w. The quotes are looked
+// up in the transcript of their source. A question the model does not
+// mention is a gap.
+func checkCoverage(w io.Writer, gen generation, systemInstruction string, questions []guideQuestion, sources []source) (*coverageMatrix, error) {
+	ctx := context.Background()
+	client, err := genai.NewClient(ctx, gen.projectID, gen.location)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create client: %w", err)
+	}
+	defer client.Close()
+
+	var numbered strings.Builder
+	for _, q := range questions {
+		fmt.Fprintf(&numbered, "%d. %s\n", q.Number, q.Text)
+	}
+	m := &coverageMatrix{Questions: make([]questionCoverage, len(questions))}
+	for i, q := range questions {
+		m.Questions[i].guideQuestion = q
+	}
+
+	if _, err := io.WriteString(w, "\n\nGuide coverage:\n"); err != nil {
+		return m, fmt.Errorf("failed to write coverage: %w", err)
+	}
+	j := jsonGenerator{client: client, gen: gen, systemInstruction: systemInstruction, schema: guideSchema, phase: phaseGuide}
+	for _, src := range sources {
+		file := src.label()
+		logger.Info("checking the guide coverage", "file", src.Path, "questions", len(questions))
+
+		var results []guideResult
+		estimate := int32((len(guidePrompt) + numbered.Len() + len(src.Transcript)) / 4)
+		if err := j.generate(ctx, estimate, &results, genai.Text(guidePrompt), genai.Text("Guide:\n"+numbered.String()), genai.Text(labelTranscript(src))); err != nil {
+			return m, fmt.Errorf("failed to check the coverage of %s: %w", src.Path, err)
+		}
+
+		answers := make([]guideAnswer, len(questions))
+		for i := range answers {
+			answers[i] = guideAnswer{File: file, Coverage: coverageGap}
+		}
+		for _, r := range results {
+			if r.Question < 1 || r.Question > len(questions) {
+				logger.Warn("ignoring the coverage of an unknown question", "question", r.Question, "file", src.Path)
+				continue
+			}
+			a := &answers[r.Question-1]
+			a.Coverage, a.Answer, a.Speaker = r.Coverage, strings.TrimSpace(r.Answer), r.Speaker
+			if r.Coverage != coverageCovered && r.Coverage != coveragePartial {
+				a.Coverage = coverageGap
+			}
+			if text := strings.TrimSpace(r.Quote); text != "" {
+				q := verifyQuotes([]quote{{Text: text, File: file, Speaker: r.Speaker}}, []source{src}, quotesFlag)[0]
+				a.Quote, a.Verified = q.Text, q.Verified
+			}
+		}
+
+		m.Files = append(m.Files, file)
+		covered := 0
+		for i, a := range answers {
+			m.Questions[i].Answers = append(m.Questions[i].Answers, a)
+			if a.Coverage == coverageGap {
+				m.Questions[i].Gaps++
+			} else {
+				covered++
+			}
+		}
+		fmt.Fprintf(w, "- %s: %d/%d questions covered\n", file, covered, len(questions))
+		if err := flush(w); err != nil {
+			return m, err
+		}
+	}
+	return m, nil
+}
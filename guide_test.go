@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestLoadGuide tests that the items of the lists are the questions, in their section
+func TestLoadGuide(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "guide.md")
+	guide := "# Discussion guide\n\nIntroduce yourself and thank the participant.\n\n## Payment\n\n1. How do you pay online?\n2. What slows you down at checkout?\n   - Probe: mobile vs desktop\n\n## Wrap-up\n\n- Anything else?\n"
+	if err := os.WriteFile(path, []byte(guide), 0o644); err != nil {
+		t.Fatalf("failed to write guide: %v", err)
+	}
+	questions, err := loadGuide(path)
+	if err != nil {
+		t.Fatalf("loadGuide failed: %v", err)
+	}
+	expected := []guideQuestion{
+		{Number: 1, Section: "Payment", Text: "How do you pay online?"},
+		{Number: 2, Section: "Payment", Text: "What slows you down at checkout?"},
+		{Number: 3, Section: "Payment", Text: "Probe: mobile vs desktop"},
+		{Number: 4, Section: "Wrap-up", Text: "Anything else?"},
+	}
+	if !reflect.DeepEqual(questions, expected) {
+		t.Errorf("loadGuide() = %+v, expected %+v", questions, expected)
+	}
+
+	// Without list, every line is a question.
+	if err := os.WriteFile(path, []byte("How do you pay online?\n\nWhy?\n"), 0o644); err != nil {
+		t.Fatalf("failed to write guide: %v", err)
+	}
+	if questions, err := loadGuide(path); err != nil || len(questions) != 2 || questions[1].Text != "Why?" {
+		t.Errorf("Unexpected questions: %+v, %v", questions, err)
+	}
+
+	if err := os.WriteFile(path, []byte("# Title only\n"), 0o644); err != nil {
+		t.Fatalf("failed to write guide: %v", err)
+	}
+	if _, err := loadGuide(path); err == nil {
+		t.Error("Expected an error for a guide without question")
+	}
+}
+
+// TestDefaultReportTemplateCoverage tests the coverage matrix and the highlighting of the gaps
+func TestDefaultReportTemplateCoverage(t *testing.T) {
+	tmpl, err := loadReportTemplate("")
+	if err != nil {
+		t.Fatalf("failed to load default template: %v", err)
+	}
+	rep := testReport()
+	rep.Coverage = &coverageMatrix{
+		Files: []string{"a.m4a", "b.m4a"},
+		Questions: []questionCoverage{
+			{guideQuestion: guideQuestion{Number: 1, Text: "How do you pay?"}, Answers: []guideAnswer{
+				{File: "a.m4a", Coverage: coverageCovered, Answer: "By card.", Quote: "always my card", Speaker: "Speaker B", Verified: true},
+				{File: "b.m4a", Coverage: coveragePartial, Answer: "Unclear.", Quote: "I guess", Verified: false},
+			}},
+			{guideQuestion: guideQuestion{Number: 2, Text: "Why?"}, Gaps: 1, Answers: []guideAnswer{
+				{File: "a.m4a", Coverage: coverageGap},
+				{File: "b.m4a", Coverage: coverageCovered, Answer: "Habit."},
+			}},
+		},
+	}
+	var buf bytes.Buffer
+	if err := rep.render(&buf, tmpl); err != nil {
+		t.Fatalf("render failed: %v", err)
+	}
+	for _, expected := range []string{
+		"## Discussion guide coverage\n\n| # | Question | a.m4a | b.m4a |\n|---|----------|---|---|\n| 1 | How do you pay? | covered | partial |\n| 2 | Why? | **gap** | covered |\n",
+		"Gaps to probe in the next round:\n\n- 2. Why?: not discussed in 1 of 2 interviews\n",
+		"### 1. How do you pay?\n\n- **a.m4a** (covered): By card.\n  > always my card (Speaker B)\n- **b.m4a** (partial): Unclear.\n  > I guess (not found in the transcript)\n",
+		"### 2. Why?\n\n- **a.m4a** (gap)\n- **b.m4a** (covered): Habit.\n",
+	} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("Expected report to contain %q, got:\n%s", expected, buf.String())
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"cloud.google.com/go/vertexai/genai"
+)
+
+// jsonGenerator runs the calls whose response is a JSON document following
+// a schema, such as the extraction of the quotes.
+type jsonGenerator struct {
+	client *genai.Client
+	// gen holds the model chain and the ledger. Once a model of the chain
+	// falls back, the next calls use the fallback.
+	gen generation
+	// systemInstruction frames every call, if not empty.
+	systemInstruction string
+	schema            *genai.Schema
+	// phase is the phase of the calls in the ledger.
+	phase string
+}
+
+// newModel returns the model of gen, answering in JSON with the schema.
+func (j *jsonGenerator) newModel(gen generation) *genai.GenerativeModel {
+	model := gen.newModel(j.client)
+	if j.systemInstruction != "" {
+		model.SystemInstruction = genai.NewUserContent(genai.Text(joinInstructions(gen.settings.SystemInstruction, j.systemInstruction)))
+	}
+	model.ResponseMIMEType = "application/json"
+	model.ResponseSchema = j.schema
+	return model
+}
+
+// groups returns the groups of sources sent in turn with the parts: all the
+// sources at once, or one at a time if they exceed tokenLimit tokens with the
+// parts. It also returns the number of tokens of the parts and all the
+// sources.
+func (j *jsonGenerator) groups(ctx context.Context, sources []source, tokenLimit int32, parts ...genai.Part) ([][]source, int32) {
+	corpus := formatCorpus(sources)
+	res, err := j.newModel(j.gen).CountTokens(ctx, append(parts, genai.Text(corpus))...)
+	if err != nil {
+		logger.Warn("unable to count tokens, sending the transcripts in a single call", "error", err)
+		tokens := len(corpus)
+		for _, part := range parts {
+			if text, ok := part.(genai.Text); ok {
+				tokens += len(text)
+			}
+		}
+		return [][]source{sources}, int32(tokens / 4)
+	}
+	if res.TotalTokens <= tokenLimit || len(sources) < 2 {
+		return [][]source{sources}, res.TotalTokens
+	}
+	logger.Info("input too large, sending one transcript at a time", "tokens", res.TotalTokens, "limit", tokenLimit, "phase", j.phase)
+	groups := make([][]source, 0, len(sources))
+	for _, src := range sources {
+		groups = append(groups, []source{src})
+	}
+	return groups, res.TotalTokens
+}
+
+// generate sends the parts and decodes the response into v. The call is not
+// made if its estimated usage, with promptTokens tokens of input, exceeds
+// the budget.
+func (j *jsonGenerator) generate(ctx context.Context, promptTokens int32, v any, parts ...genai.Part) error {
+	estimate := tokenUsage{Prompt: promptTokens, Candidates: synthesisOutputTokens}
+	estimate.Total = estimate.Prompt + estimate.Candidates
+	if err := j.gen.check(j.phase, estimate); err != nil {
+		return err
+	}
+
+	var text string
+	gen, err := j.gen.withFallback(func(gen generation) error {
+		var (
+			usage tokenUsage
+			err   error
+		)
+		text, usage, err = generateText(ctx, j.newModel(gen), nil, gen.checker(j.phase), parts...)
+		gen.record(j.phase, "", "", usage)
+		return err
+	})
+	j.gen = gen
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal([]byte(text), v); err != nil {
+		return fmt.Errorf("invalid response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/tabwriter"
+)
+
+// Phases of a run, as recorded in the ledger.
+const (
+	phaseTranscription  = "transcription"
+	phasePartialSummary = "partial summary"
+	phaseSynthesis      = "synthesis"
+	phaseQuotes         = "quotes"
+	phaseGrounding      = "grounding"
+	phaseThemes         = "themes"
+	phasePersonas       = "personas"
+	phaseMinutes        = "minutes"
+	phaseGuide          = "guide"
+	phaseQuestion       = "question"
+)
+
+// price is the cost of a model in USD per million tokens.
+type price struct {
+	Input      float64 `json:"input" yaml:"input"`
+	AudioInput float64 `json:"audio_input" yaml:"audio_input"`
+	Output     float64 `json:"output" yaml:"output"`
+}
+
+// priceTable maps a model name to its price.
+type priceTable map[string]price
+
+// defaultPrices are the list prices of Vertex AI at the time of writing. They
+// are estimates: override them with -prices for accurate billing.
+var defaultPrices = priceTable{
+	"gemini-2.0-flash":      {Input: 0.15, AudioInput: 1.00, Output: 0.60},
+	"gemini-2.0-flash-lite": {Input: 0.075, AudioInput: 0.075, Output: 0.30},
+	"gemini-2.5-flash":      {Input: 0.30, AudioInput: 1.00, Output: 2.50},
+	"gemini-2.5-pro":        {Input: 1.25, AudioInput: 1.25, Output: 10.00},
+}
+
+// loadPrices returns the default prices overridden by base (the prices of
+// the configuration profile), then by the JSON file at path, if any. The file
+// maps model names to {"input", "audio_input", "output"}.
+func loadPrices(path string, base priceTable) (priceTable, error) {
+	prices := make(priceTable, len(defaultPrices)+len(base))
+	for model, p := range defaultPrices {
+		prices[model] = p
+	}
+	for model, p := range base {
+		prices[model] = p
+	}
+	if path == "" {
+		return prices, nil
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read prices: %w", err)
+	}
+	var overrides priceTable
+	if err := json.Unmarshal(content, &overrides); err != nil {
+		return nil, fmt.Errorf("unable to parse prices: %w", err)
+	}
+	for model, p := range overrides {
+		prices[model] = p
+	}
+	return prices, nil
+}
+
+// lookup returns the price of the model. Versioned names such as
+// gemini-2.0-flash-001 match their base model.
+func (t priceTable) lookup(model string) (price, bool) {
+	model = filepath.Base(model)
+	if p, ok := t[model]; ok {
+		return p, true
+	}
+	best := ""
+	for name := range t {
+		if strings.HasPrefix(model, name+"-") && len(name) > len(best) {
+			best = name
+		}
+	}
+	p, ok := t[best]
+	return p, ok
+}
+
+// cost estimates the cost of a call. The prompt of a transcription is priced
+// as audio.
+func (t priceTable) cost(model, phase string, u tokenUsage) float64 {
+	p, ok := t.lookup(model)
+	if !ok {
+		return 0
+	}
+	input := p.Input
+	if phase == phaseTranscription && p.AudioInput > 0 {
+		input = p.AudioInput
+	}
+	return (float64(u.Prompt)*input + float64(u.Candidates)*p.Output) / 1e6
+}
+
+// errBudget is returned when the usage of a run reaches its budget.
+var errBudget = errors.New("budget reached")
+
+// budget caps the usage of a run. A zero field means no limit.
+type budget struct {
+	MaxCost   float64
+	MaxTokens int32
+}
+
+// exceeded returns an error wrapping errBudget if usage or cost is above the
+// budget.
+func (b budget) exceeded(usage tokenUsage, cost float64) error {
+	if b.MaxTokens > 0 && usage.Total > b.MaxTokens {
+		return fmt.Errorf("%w: %d tokens, limit %d (-max-tokens)", errBudget, usage.Total, b.MaxTokens)
+	}
+	if b.MaxCost > 0 && cost > b.MaxCost {
+		return fmt.Errorf("%w: %.4f USD, limit %.4f USD (-max-cost)", errBudget, cost, b.MaxCost)
+	}
+	return nil
+}
+
+// ledgerEntry is the usage of a call to the model.
+type ledgerEntry struct {
+	Phase string
+	File  string
+	Chunk string
+	Model string
+	Usage tokenUsage
+	// Cost is the estimated cost in USD, 0 if the model has no price.
+	Cost float64
+}
+
+// ledger records the usage of every call of a run. A nil ledger records
+// nothing.
+type ledger struct {
+	prices priceTable
+	limit  budget
+
+	mu      sync.Mutex
+	entries []ledgerEntry
+}
+
+func newLedger(prices priceTable, limit budget) *ledger {
+	return &ledger{prices: prices, limit: limit}
+}
+
+func (l *ledger) record(phase, file, chunk, model string, u tokenUsage) {
+	if l == nil {
+		return
+	}
+	if _, ok := l.prices.lookup(model); !ok {
+		logger.Warn("no price for model, cost not estimated", "model", model)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = append(l.entries, ledgerEntry{
+		Phase: phase,
+		File:  file,
+		Chunk: chunk,
+		Model: model,
+		Usage: u,
+		Cost:  l.prices.cost(model, phase, u),
+	})
+}
+
+// Entries returns a copy of the recorded entries.
+func (l *ledger) Entries() []ledgerEntry {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]ledgerEntry(nil), l.entries...)
+}
+
+// total returns the usage and the estimated cost of every recorded call.
+func (l *ledger) total() (tokenUsage, float64) {
+	var (
+		usage tokenUsage
+		cost  float64
+	)
+	for _, e := range l.Entries() {
+		usage.add(e.Usage)
+		cost += e.Cost
+	}
+	return usage, cost
+}
+
+// check returns an error wrapping errBudget if the usage recorded so far,
+// plus the estimated usage of the next call to model, exceeds the budget.
+// With a zero estimate, it checks the actual usage of the calls made.
+func (l *ledger) check(phase, model string, estimate tokenUsage) error {
+	if l == nil {
+		return nil
+	}
+	usage, cost := l.total()
+	usage.add(estimate)
+	cost += l.prices.cost(model, phase, estimate)
+	return l.limit.exceeded(usage, cost)
+}
+
+// print writes the ledger as a table.
+func (l *ledger) print(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PHASE\tFILE\tCHUNK\tMODEL\tPROMPT\tCANDIDATES\tTOTAL\tCOST (USD)\t")
+	for _, e := range l.Entries() {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%d\t%d\t%.4f\t\n",
+			e.Phase, orDash(filepath.Base(e.File)), orDash(e.Chunk), e.Model, e.Usage.Prompt, e.Usage.Candidates, e.Usage.Total, e.Cost)
+	}
+	usage, cost := l.total()
+	fmt.Fprintf(tw, "TOTAL\t-\t-\t-\t%d\t%d\t%d\t%.4f\t\n", usage.Prompt, usage.Candidates, usage.Total, cost)
+	return tw.Flush()
+}
+
+func orDash(s string) string {
+	if s == "" || s == "." {
+		return "-"
+	}
+	return s
+}
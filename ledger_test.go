@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestPriceLookup tests that versioned model names match their base model
+func TestPriceLookup(t *testing.T) {
+	prices := priceTable{
+		"gemini-2.0-flash":      {Input: 1},
+		"gemini-2.0-flash-lite": {Input: 2},
+	}
+	tests := map[string]float64{
+		"gemini-2.0-flash":                               1,
+		"gemini-2.0-flash-001":                           1,
+		"gemini-2.0-flash-lite-001":                      2,
+		"publishers/google/models/gemini-2.0-flash-lite": 2,
+	}
+	for model, expected := range tests {
+		p, ok := prices.lookup(model)
+		if !ok || p.Input != expected {
+			t.Errorf("lookup(%q) = %v, %v; expected input %v", model, p, ok, expected)
+		}
+	}
+	if _, ok := prices.lookup("gemini-1.5-pro"); ok {
+		t.Error("Expected no price for an unknown model")
+	}
+}
+
+// TestCost tests that transcription prompts are priced as audio
+func TestCost(t *testing.T) {
+	prices := priceTable{"m": {Input: 1, AudioInput: 4, Output: 10}}
+	u := tokenUsage{Prompt: 1_000_000, Candidates: 100_000, Total: 1_100_000}
+
+	if got := prices.cost("m", phaseTranscription, u); math.Abs(got-5) > 1e-9 {
+		t.Errorf("Expected transcription cost 5, got %v", got)
+	}
+	if got := prices.cost("m", phaseSynthesis, u); math.Abs(got-2) > 1e-9 {
+		t.Errorf("Expected synthesis cost 2, got %v", got)
+	}
+	if got := prices.cost("unknown", phaseSynthesis, u); got != 0 {
+		t.Errorf("Expected no cost for an unknown model, got %v", got)
+	}
+}
+
+// TestLoadPrices tests overriding the default prices with a file
+func TestLoadPrices(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "prices.json")
+	os.WriteFile(path, []byte(`{"gemini-2.0-flash": {"input": 0.2, "audio_input": 2, "output": 0.8}, "custom": {"input": 1, "output": 1}}`), 0o644)
+
+	prices, err := loadPrices(path, nil)
+	if err != nil {
+		t.Fatalf("loadPrices failed: %v", err)
+	}
+	if prices["gemini-2.0-flash"].AudioInput != 2 {
+		t.Errorf("Expected the override to apply, got %+v", prices["gemini-2.0-flash"])
+	}
+	if _, ok := prices["custom"]; !ok {
+		t.Error("Expected the custom model to be added")
+	}
+	if _, ok := prices["gemini-2.5-pro"]; !ok {
+		t.Error("Expected the default prices to be kept")
+	}
+	if defaultPrices["gemini-2.0-flash"].AudioInput == 2 {
+		t.Error("The default prices were modified")
+	}
+}
+
+// TestLedger tests the totals and the printed table
+func TestLedger(t *testing.T) {
+	l := newLedger(priceTable{"m": {Input: 1, Output: 1}}, budget{})
+	l.record(phaseTranscription, "/tmp/a.m4a", "", "m", tokenUsage{Prompt: 100, Candidates: 50, Total: 150})
+	l.record(phaseTranscription, "/tmp/b.m4a", "1/2", "m", tokenUsage{Prompt: 100, Candidates: 50, Total: 150})
+	l.record(phaseSynthesis, "", "", "m", tokenUsage{Prompt: 200, Candidates: 100, Total: 300})
+
+	usage, cost := l.total()
+	if usage.Total != 600 || usage.Prompt != 400 || usage.Candidates != 200 {
+		t.Errorf("Unexpected total usage: %+v", usage)
+	}
+	if math.Abs(cost-600e-6) > 1e-12 {
+		t.Errorf("Expected cost 0.0006, got %v", cost)
+	}
+
+	var buf bytes.Buffer
+	if err := l.print(&buf); err != nil {
+		t.Fatalf("print failed: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 5 {
+		t.Fatalf("Expected a header, 3 entries and a total, got:\n%s", buf.String())
+	}
+	if !strings.Contains(lines[2], "b.m4a") || !strings.Contains(lines[2], "1/2") {
+		t.Errorf("Unexpected entry line: %q", lines[2])
+	}
+	if !strings.HasPrefix(lines[4], "TOTAL") || !strings.Contains(lines[4], "600") {
+		t.Errorf("Unexpected total line: %q", lines[4])
+	}
+
+	var nilLedger *ledger
+	nilLedger.record(phaseSynthesis, "", "", "m", tokenUsage{Total: 1})
+	if nilLedger.Entries() != nil {
+		t.Error("Expected a nil ledger to record nothing")
+	}
+}
+
+// TestLedgerCheck tests the budget checks made before and after a call
+func TestLedgerCheck(t *testing.T) {
+	l := newLedger(priceTable{"m": {Input: 1, Output: 1}}, budget{MaxCost: 0.001, MaxTokens: 900})
+	l.record(phaseTranscription, "a.m4a", "", "m", tokenUsage{Prompt: 300, Candidates: 200, Total: 500})
+
+	if err := l.check(phaseTranscription, "m", tokenUsage{}); err != nil {
+		t.Errorf("Expected the actual usage to be within the budget, got %v", err)
+	}
+	err := l.check(phaseSynthesis, "m", tokenUsage{Prompt: 300, Candidates: 200, Total: 500})
+	if !errors.Is(err, errBudget) || !strings.Contains(err.Error(), "-max-tokens") {
+		t.Errorf("Expected the token limit to be reached by the estimate, got %v", err)
+	}
+
+	l.limit.MaxTokens = 0
+	if err := l.check(phaseSynthesis, "m", tokenUsage{Prompt: 300, Candidates: 200, Total: 500}); err != nil {
+		t.Errorf("Expected 0.001 USD to be within the budget, got %v", err)
+	}
+	l.record(phaseSynthesis, "", "", "m", tokenUsage{Prompt: 400, Candidates: 200, Total: 600})
+	err = l.check(phaseSynthesis, "m", tokenUsage{})
+	if !errors.Is(err, errBudget) || !strings.Contains(err.Error(), "-max-cost") {
+		t.Errorf("Expected the cost limit to be reached, got %v", err)
+	}
+
+	var nilLedger *ledger
+	if err := nilLedger.check(phaseSynthesis, "m", tokenUsage{Total: 1 << 30}); err != nil {
+		t.Errorf("Expected a nil ledger to have no budget, got %v", err)
+	}
+}
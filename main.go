@@ -2,103 +2,479 @@ package main
 
 import (
 	"bufio"
-	"flag"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"os"
 	"strings"
+	"text/template"
+	"time"
 
-	"github.com/kelseyhightower/envconfig"
+	"cloud.google.com/go/vertexai/genai"
 )
 
 type configuration struct {
-	GCPProject  string `envconfig:"GCP_PROJECT" required:"true"`
-	GeminiModel string `envconfig:"GEMINI_MODEL" default:"gemini-2.0-flash"`
-	GCPRegion   string `envconfig:"GCP_REGION" default:"europe-west9"`
+	GCPProject string `envconfig:"GCP_PROJECT" required:"true"`
+	// GeminiModel is a comma separated list of models, tried in turn when a
+	// model is unavailable. The model of a phase overrides it.
+	GeminiModel   string        `envconfig:"GEMINI_MODEL" default:"gemini-2.0-flash"`
+	Transcription phaseSettings `envconfig:"GEMINI_TRANSCRIPTION"`
+	Synthesis     phaseSettings `envconfig:"GEMINI_SYNTHESIS"`
+	GCPRegion     string        `envconfig:"GCP_REGION" default:"europe-west9"`
+	GCSBucket     string        `envconfig:"GCS_BUCKET"`
 }
 
-var logger *slog.Logger
+// options holds the settings given on the command line.
+type options struct {
+	outputFile string
+	appendMode bool
+	template   *template.Template
+	prompts    prompts
+	// contextFiles are the project documents framing the synthesis, context
+	// is their content formatted as a system instruction.
+	contextFiles []string
+	context      string
+	glossary     glossary
+	// summaryTokenLimit is the size above which the synthesis is done in a
+	// map-reduce fashion.
+	summaryTokenLimit int32
+	// safetySettings, onBlocked and fallbackModel control what happens when
+	// the model blocks a file.
+	safetySettings []*genai.SafetySetting
+	onBlocked      []string
+	fallbackModel  string
+	// cache reuses and stores the transcripts in the transcript cache.
+	cache  bool
+	stream bool
+	prices priceTable
+	// budget stops the run before its usage exceeds the limits.
+	budget budget
+	// quotes is the verification mode of the key quotes extracted after the
+	// synthesis, see -quotes; no quotes are extracted if empty.
+	quotes string
+	// grounding checks the items of the synthesis against the transcripts.
+	grounding bool
+	// structured asks for a synthesis following structuredSchema, and
+	// jsonFile is the path of the JSON export of the report, if any.
+	structured bool
+	jsonFile   string
+	// themes clusters the findings into themes across the interviews.
+	themes bool
+	// personas drafts the personas and the journey map of the interviews.
+	personas bool
+	// minutes extracts the decisions, action items and open questions, and
+	// tasksFile is the path of their export, if any.
+	minutes   bool
+	tasksFile string
+	// guide are the questions of the discussion guide whose coverage by
+	// every interview is checked, if any.
+	guide []guideQuestion
+	// skipSynthesis stops the run after the transcription; fromTranscripts
+	// reads the transcripts from the files instead of transcribing them.
+	skipSynthesis   bool
+	fromTranscripts bool
+}
 
-func main() {
-	logger = slog.New(slog.NewTextHandler(os.Stderr, nil))
+// stringsFlag is a flag that can be repeated.
+type stringsFlag []string
 
-	var config configuration
-	err := envconfig.Process("", &config)
-	if err != nil {
-		logger.Error("failed to process environment variables", "error", err)
-		envconfig.Usage("", &config)
-		os.Exit(1)
-	}
+func (s *stringsFlag) String() string { return strings.Join(*s, ",") }
+
+func (s *stringsFlag) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
 
-	var (
-		outputFile = flag.String("o", "", "Path to the output file. If empty, stdout will be used.")
-		help       = flag.Bool("h", false, "Help")
-	)
-	flag.Parse()
+var logger = slog.Default()
 
-	if *help {
-		envconfig.Usage("", &config)
-		flag.Usage()
-		os.Exit(1)
-	}
+func main() {
+	logger = slog.New(slog.NewTextHandler(os.Stderr, nil))
 
-	// Get audio files from positional arguments
-	filePaths := flag.Args()
-	if len(filePaths) == 0 {
-		logger.Error("at least one audio file required as argument")
-		fmt.Fprintf(os.Stderr, "Usage: %s [-o output.md] audio1.m4a [audio2.m4a ...]\n", os.Args[0])
-		flag.Usage()
+	cmd, args := lookupCommand(os.Args[1:])
+	if err := cmd.run(cmd, args); err != nil {
+		if errors.Is(err, errBudget) {
+			logger.Error("run stopped, partial report saved", "error", err)
+			os.Exit(1)
+		}
+		logger.Error(cmd.name+" failed", "error", err)
 		os.Exit(1)
 	}
+}
+
+// newGeneration returns the generation settings of a run, using the
+// GEMINI_MODEL chain. Use forPhase to get the models and the settings of a
+// phase; the ledger is shared.
+func newGeneration(config configuration, opts options) generation {
+	return generation{
+		projectID:      config.GCPProject,
+		location:       config.GCPRegion,
+		safetySettings: opts.safetySettings,
+		stream:         opts.stream,
+		ledger:         newLedger(opts.prices, opts.budget),
+		bucket:         config.GCSBucket,
+	}.withModels(parseModels(config.GeminiModel))
+}
 
-	// Determine the output writer.
+// run transcribes every file, synthesizes the transcripts and renders the
+// report. The transcripts are streamed as they come to stderr, or to
+// outputFile.progress when an output file is set so that they can be followed
+// with tail -f. The output file itself is written atomically.
+//
+// With opts.fromTranscripts, the files are transcripts loaded instead of
+// transcribed; with opts.skipSynthesis, the report holds the transcripts only.
+//
+// When the budget is reached, the run stops before the next call: the report
+// is saved with the transcripts made so far and an error wrapping errBudget
+// is returned.
+func run(config configuration, opts options, filePaths []string) (err error) {
+	// Determine the output and progress writers.
 	var outputWriter io.Writer = os.Stdout
+	var progressWriter io.Writer = os.Stderr
 	var bufWriter *bufio.Writer
-	if *outputFile != "" {
-		f, err := os.Create(*outputFile)
+	appended := false
+	var out *atomicFile
+	if opts.outputFile != "" {
+		out, err = createAtomic(opts.outputFile, opts.appendMode)
 		if err != nil {
-			logger.Error("failed to create output file", "error", err)
-			os.Exit(1)
+			return fmt.Errorf("failed to create output file: %w", err)
+		}
+		progressFile := opts.outputFile + ".progress"
+		var progress *os.File
+		progress, err = os.Create(progressFile)
+		if err != nil {
+			out.Abort()
+			return fmt.Errorf("failed to create progress file: %w", err)
 		}
-		defer f.Close()
-		bufWriter = bufio.NewWriter(f)
-		outputWriter = bufWriter
-		defer bufWriter.Flush()
+		defer func() {
+			progress.Close()
+			if errors.Is(err, errBudget) {
+				// Save the partial report; the progress file holds the
+				// partial summaries, if any.
+				if cerr := out.Commit(); cerr != nil {
+					err = fmt.Errorf("failed to save output file: %w", cerr)
+				}
+				return
+			}
+			if err != nil {
+				// Keep the progress file: it holds the partial results.
+				out.Abort()
+				return
+			}
+			if err = out.Commit(); err != nil {
+				err = fmt.Errorf("failed to save output file: %w", err)
+				return
+			}
+			os.Remove(progressFile)
+		}()
+		logger.Info("writing output", "file", opts.outputFile, "progress", progressFile, "append", opts.appendMode)
+
+		outputWriter = out
+		bufWriter = bufio.NewWriter(progress)
+		progressWriter = bufWriter
+		if out.existing > 0 {
+			appended = true
+			if _, err := io.WriteString(outputWriter, "\n\n---\n\n"); err != nil {
+				return fmt.Errorf("failed to write output: %w", err)
+			}
+		}
+	}
+
+	gen := newGeneration(config, opts)
+	// Print the usage even if the run fails: the calls made are billed.
+	defer func() {
+		fmt.Fprintln(os.Stderr, "\nUsage:")
+		gen.ledger.print(os.Stderr)
+	}()
+
+	transcriber := gen.forPhase(config, phaseTranscription)
+	synthesizer := gen.forPhase(config, phaseSynthesis)
+
+	rep := report{
+		Title:         reportTitle(opts.outputFile, filePaths),
+		Date:          time.Now(),
+		Model:         synthesizer.model,
+		PromptVersion: opts.prompts.version(),
+		ContextFiles:  opts.contextFiles,
+		Appended:      appended,
+	}
+	if opts.skipSynthesis {
+		rep.Model = transcriber.model
 	}
 
-	// Transcribe all audio files using Vertex AI.
-	logger.Info("transcribing audio files", "count", len(filePaths))
 	var allTranscripts []string
+	var stopped error
+	transcriptionPrompt := opts.prompts.Transcription + opts.glossary.hint()
+
+	var cache *transcriptCache
+	if opts.cache {
+		if cache, err = openTranscriptCache(); err != nil {
+			return err
+		}
+	}
+
+	if opts.fromTranscripts {
+		for _, path := range filePaths {
+			sources, err := loadTranscripts(path)
+			if err != nil {
+				return fmt.Errorf("failed to load transcripts: %w", err)
+			}
+			logger.Info("transcripts loaded", "file", path, "count", len(sources))
+			for _, src := range sources {
+				var corrections []correction
+				src.Transcript, corrections = opts.glossary.correct(src.Path, src.Transcript)
+				rep.Corrections = append(rep.Corrections, corrections...)
+				allTranscripts = append(allTranscripts, src.Transcript)
+				rep.Sources = append(rep.Sources, src)
+			}
+		}
+		filePaths = nil
+	} else {
+		// Transcribe all audio files using Vertex AI.
+		logger.Info("transcribing audio files", "count", len(filePaths))
+	}
 
 	for i, audioFilePath := range filePaths {
 		logger.Info("transcribing audio file", "file", audioFilePath, "progress", fmt.Sprintf("%d/%d", i+1, len(filePaths)))
 
-		transcript, err := transcribeAudio(outputWriter, config.GCPProject, config.GCPRegion, config.GeminiModel, audioFilePath)
+		duration, err := probeDuration(context.Background(), audioFilePath)
 		if err != nil {
-			logger.Error("failed to transcribe audio file", "file", audioFilePath, "error", err)
-			os.Exit(1)
+			logger.Warn("unable to get audio duration", "file", audioFilePath, "error", err)
+		}
+
+		var key string
+		if cache != nil {
+			key, err = cache.key(audioFilePath, transcriber, transcriptionPrompt)
+			if err != nil {
+				return err
+			}
+			if entry, ok := cache.get(key); ok {
+				logger.Info("transcript found in the cache", "file", audioFilePath, "model", entry.Model)
+				transcript, corrections := opts.glossary.correct(audioFilePath, entry.Transcript)
+				rep.Corrections = append(rep.Corrections, corrections...)
+				allTranscripts = append(allTranscripts, transcript)
+				rep.Sources = append(rep.Sources, source{Path: audioFilePath, Duration: duration, Transcript: transcript, Model: entry.Model})
+				continue
+			}
+		}
+
+		estimated := duration
+		if estimated == 0 {
+			if fi, err := os.Stat(audioFilePath); err == nil {
+				estimated = estimateDuration(fi.Size())
+			}
+		}
+		if stopped = transcriber.check(phaseTranscription, estimateTranscription(estimated, transcriptionPrompt, 1)); stopped != nil {
+			break
+		}
+
+		src, inc, err := transcribeWithFallback(progressWriter, transcriber, opts.fallbackModel, transcriptionPrompt, audioFilePath, opts.onBlocked)
+		if inc != nil {
+			if inc.Outcome == "" {
+				inc.Outcome = "failed"
+			}
+			rep.Incidents = append(rep.Incidents, *inc)
+		}
+		if errors.Is(err, errBudget) {
+			stopped = err
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to transcribe audio file %s: %w", audioFilePath, err)
+		}
+
+		if cache != nil && inc == nil {
+			if err := cache.put(key, cacheEntry{File: audioFilePath, Model: src.Model, Transcript: src.Transcript, Usage: src.Usage}); err != nil {
+				logger.Warn("unable to cache the transcript", "file", audioFilePath, "error", err)
+			}
 		}
 
 		// Flush after each transcript to ensure it's written to file
 		if bufWriter != nil {
 			if err := bufWriter.Flush(); err != nil {
-				logger.Error("failed to flush output", "error", err)
-				os.Exit(1)
+				return fmt.Errorf("failed to flush output: %w", err)
 			}
 		}
 
+		transcript, corrections := opts.glossary.correct(audioFilePath, src.Transcript)
+		for _, c := range corrections {
+			logger.Info("glossary correction", "file", c.File, "from", c.From, "to", c.To, "count", c.Count)
+		}
+		rep.Corrections = append(rep.Corrections, corrections...)
+
 		allTranscripts = append(allTranscripts, transcript)
-		logger.Info("audio file transcribed successfully", "file", audioFilePath)
+		src.Duration, src.Transcript = duration, transcript
+		rep.Sources = append(rep.Sources, src)
+		logger.Info("audio file transcribed successfully", "file", audioFilePath, "model", src.Model)
+
+		if stopped = gen.check(phaseTranscription, tokenUsage{}); stopped != nil {
+			break
+		}
 	}
 
-	// Combine all transcripts
-	combinedTranscript := strings.Join(allTranscripts, "\n\n---\n\n")
+	// The files are named by their label in the prompts and the quotes.
+	labelSources(rep.Sources)
 
-	err = postProcess(combinedTranscript, outputWriter, config.GCPProject, config.GCPRegion, config.GeminiModel)
-	if err != nil {
-		logger.Error("failed to do the post-processing", "error", err)
-		os.Exit(1)
+	if stopped == nil && !opts.skipSynthesis {
+		transcripts, prompt, schema := allTranscripts, opts.prompts.Summary, (*genai.Schema)(nil)
+		if opts.structured {
+			// The files are named so that the quotes can be attributed.
+			transcripts = make([]string, len(rep.Sources))
+			for i, src := range rep.Sources {
+				transcripts[i] = labelTranscript(src)
+			}
+			prompt, schema = prompt+structuredNote, structuredSchema
+		}
+		synthesis, usage, model, err := postProcess(transcripts, progressWriter, synthesizer, prompt, opts.context, opts.summaryTokenLimit, schema)
+		switch {
+		case errors.Is(err, errBudget):
+			stopped = err
+		case err != nil:
+			return fmt.Errorf("failed to do the post-processing: %w", err)
+		default:
+			rep.Synthesis, rep.Model = synthesis, model
+			logger.Info("post processing completed successfully", "tokens", usage.Total, "model", model)
+		}
+		if opts.structured && rep.Synthesis != "" {
+			// An invalid response does not lose the run: the raw synthesis
+			// is saved, without the structured sections.
+			structured, err := parseStructuredSummary(rep.Synthesis)
+			var text string
+			if err == nil {
+				text, err = structured.markdown()
+			}
+			if err != nil {
+				logger.Warn("keeping the raw synthesis", "error", err)
+				rep.Synthesis = rawStructured(rep.Synthesis, err)
+			} else {
+				rep.Structured, rep.Synthesis = structured, text
+			}
+		}
+	}
+	if rep.Structured != nil {
+		// The quotes come with the structured synthesis: they are verified
+		// and flagged if not found, unless -quotes says otherwise.
+		mode := opts.quotes
+		if mode == "" {
+			mode = quotesFlag
+		}
+		n := len(rep.Structured.Quotes)
+		rep.Quotes = verifyQuotes(rep.Structured.Quotes, rep.Sources, mode)
+		rep.Structured.Quotes = rep.Quotes
+		logger.Info("quotes verified", "quotes", n, "kept", len(rep.Quotes))
+	} else if stopped == nil && rep.Synthesis != "" && opts.quotes != "" {
+		quotes, err := extractQuotes(progressWriter, synthesizer, opts.context, rep.Synthesis, rep.Sources, opts.summaryTokenLimit)
+		switch {
+		case errors.Is(err, errBudget):
+			stopped = err
+		case err != nil:
+			rep.fail("Key quotes", err)
+			quotes = nil
+		}
+		rep.Quotes = verifyQuotes(quotes, rep.Sources, opts.quotes)
+		logger.Info("quotes extracted", "quotes", len(quotes), "kept", len(rep.Quotes))
+	}
+	if stopped == nil && !opts.skipSynthesis && len(rep.Sources) > 0 && opts.themes {
+		themes, err := clusterThemes(progressWriter, synthesizer, opts.context, rep.Synthesis, rep.Sources, opts.summaryTokenLimit)
+		switch {
+		case errors.Is(err, errBudget):
+			stopped = err
+		case err != nil:
+			rep.fail("Themes", err)
+		default:
+			rep.Themes = themes
+			logger.Info("themes clustered", "count", len(themes))
+		}
+	}
+	if stopped == nil && !opts.skipSynthesis && len(rep.Sources) > 0 && opts.personas {
+		p, err := draftPersonas(progressWriter, synthesizer, opts.prompts.Personas, opts.context, rep.Synthesis, rep.Sources, opts.summaryTokenLimit)
+		switch {
+		case errors.Is(err, errBudget):
+			stopped = err
+		case err != nil:
+			rep.fail("Personas", err)
+		case p == nil:
+			// The transcripts are too large to be sent at once.
+		default:
+			p.verify(rep.Sources)
+			rep.Personas = p
+			logger.Info("personas drafted", "personas", len(p.Personas), "stages", len(p.Journey))
+		}
+	}
+	if stopped == nil && !opts.skipSynthesis && len(rep.Sources) > 0 && opts.minutes {
+		m, err := extractMinutes(progressWriter, synthesizer, opts.context, rep.Sources, opts.summaryTokenLimit)
+		switch {
+		case errors.Is(err, errBudget):
+			stopped = err
+		case err != nil:
+			rep.fail("Minutes", err)
+		default:
+			m.verify(rep.Sources)
+			rep.Minutes = &m
+			logger.Info("minutes extracted", "decisions", len(m.Decisions), "action_items", len(m.ActionItems), "open_questions", len(m.OpenQuestions))
+		}
+	}
+	if stopped == nil && !opts.skipSynthesis && len(rep.Sources) > 0 && len(opts.guide) > 0 {
+		m, err := checkCoverage(progressWriter, synthesizer, opts.context, opts.guide, rep.Sources)
+		switch {
+		case errors.Is(err, errBudget):
+			stopped = err
+		case err != nil:
+			// The interviews checked before the failure are kept.
+			rep.fail("Guide coverage", err)
+		}
+		if m != nil && len(m.Files) > 0 {
+			rep.Coverage = m
+			logger.Info("guide coverage checked", "interviews", len(m.Files), "questions", len(m.Questions), "gapped", len(m.Gapped()))
+		}
+	}
+	if stopped == nil && rep.Synthesis != "" && opts.grounding {
+		claims, err := checkGrounding(progressWriter, synthesizer, opts.context, rep.Synthesis, rep.Sources, opts.summaryTokenLimit)
+		switch {
+		case errors.Is(err, errBudget):
+			stopped = err
+		case err != nil:
+			rep.fail("Grounding check", err)
+			claims = nil
+		}
+		rep.Grounding = verifyGrounding(claims, rep.Sources)
+		logger.Info("grounding checked", "claims", len(rep.Grounding), "unsupported", countUnsupported(rep.Grounding))
+	}
+	if stopped != nil {
+		logger.Warn("run stopped, saving the partial report", "transcribed", len(rep.Sources), "files", len(filePaths), "reason", stopped)
+		rep.Stopped = stopped.Error()
+	}
+
+	if bufWriter != nil {
+		if err := bufWriter.Flush(); err != nil {
+			return fmt.Errorf("failed to flush output: %w", err)
+		}
+	}
+
+	rep.Usage, rep.Cost = gen.ledger.total()
+	rep.Ledger = gen.ledger.Entries()
+	if err := rep.render(outputWriter, opts.template); err != nil {
+		return err
+	}
+	if appended {
+		// The front matter of the report appended to covers its own
+		// transcripts only.
+		if err := out.rewrite(rep.mergeFrontMatter); err != nil {
+			return fmt.Errorf("failed to update the front matter: %w", err)
+		}
+	}
+	if opts.tasksFile != "" && rep.Minutes != nil {
+		if err := rep.Minutes.writeTasks(opts.tasksFile); err != nil {
+			return fmt.Errorf("failed to export the minutes: %w", err)
+		}
+		logger.Info("minutes exported", "file", opts.tasksFile)
+	}
+	if opts.jsonFile != "" {
+		if err := rep.writeJSON(opts.jsonFile); err != nil {
+			return fmt.Errorf("failed to export the report: %w", err)
+		}
+		logger.Info("report exported", "file", opts.jsonFile)
 	}
-	logger.Info("post processing completed successfully")
+	return stopped
 }
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"cloud.google.com/go/vertexai/genai"
+)
+
+// minutesPrompt asks for the decisions, action items and open questions of
+// the meetings.
+const minutesPrompt = `Extract from the meeting transcripts below:
+
+1. The decisions that were made
+2. The action items: the task, its owner and its due date only when they are stated (empty strings otherwise)
+3. The questions left open
+
+For every entry, give the utterance it comes from, copied word for word from a single transcript, with the file of the transcript as in its <transcript file="..."> tag and the speaker as labelled in the transcript (an empty string if unknown). Write the entries in the language of the meeting.`
+
+// sourceProperties are the properties locating an entry of the minutes in
+// the transcripts.
+var sourceProperties = map[string]*genai.Schema{
+	"utterance": {Type: genai.TypeString},
+	"file":      {Type: genai.TypeString},
+	"speaker":   {Type: genai.TypeString},
+}
+
+// minutesEntrySchema returns the schema of a list of entries with the
+// properties and their source.
+func minutesEntrySchema(properties ...string) *genai.Schema {
+	item := &genai.Schema{Type: genai.TypeObject, Properties: make(map[string]*genai.Schema)}
+	for _, p := range properties {
+		item.Properties[p] = &genai.Schema{Type: genai.TypeString}
+	}
+	for p, s := range sourceProperties {
+		item.Properties[p] = s
+	}
+	item.Required = append(append([]string{}, properties...), "utterance", "file", "speaker")
+	return &genai.Schema{Type: genai.TypeArray, Items: item}
+}
+
+// minutesSchema is the schema of the response to minutesPrompt.
+var minutesSchema = &genai.Schema{
+	Type: genai.TypeObject,
+	Properties: map[string]*genai.Schema{
+		"decisions":      minutesEntrySchema("description"),
+		"action_items":   minutesEntrySchema("description", "owner", "due"),
+		"open_questions": minutesEntrySchema("description"),
+	},
+	Required: []string{"decisions", "action_items", "open_questions"},
+}
+
+// minutes are the decisions, action items and open questions of meetings.
+type minutes struct {
+	Decisions     []minutesEntry `json:"decisions"`
+	ActionItems   []minutesEntry `json:"action_items"`
+	OpenQuestions []minutesEntry `json:"open_questions"`
+}
+
+// minutesEntry is a decision, an action item or an open question, with the
+// utterance it comes from. Owner and Due are only set for action items.
+type minutesEntry struct {
+	Description string `json:"description"`
+	Owner       string `json:"owner,omitempty"`
+	Due         string `json:"due,omitempty"`
+	Utterance   string `json:"utterance"`
+	File        string `json:"file"`
+	Speaker     string `json:"speaker"`
+	// Verified is true if the utterance was found in the transcripts.
+	Verified bool `json:"verified"`
+}
+
+// extractMinutes asks the model for the minutes of the sources and writes
+// them to w. When the transcripts exceed tokenLimit tokens, the minutes are
+// extracted from one transcript at a time.
+func extractMinutes(w io.Writer, gen generation, systemInstruction string, sources []source, tokenLimit int32) (minutes, error) {
+	var m minutes
+	ctx := context.Background()
+	client, err := genai.NewClient(ctx, gen.projectID, gen.location)
+	if err != nil {
+		return m, fmt.Errorf("unable to create client: %w", err)
+	}
+	defer client.Close()
+
+	j := jsonGenerator{client: client, gen: gen, systemInstruction: systemInstruction, schema: minutesSchema, phase: phaseMinutes}
+	groups, tokens := j.groups(ctx, sources, tokenLimit, genai.Text(minutesPrompt))
+	for _, group := range groups {
+		var part minutes
+		estimate := tokens * int32(len(group)) / int32(len(sources))
+		if err := j.generate(ctx, estimate, &part, genai.Text(minutesPrompt), genai.Text(formatCorpus(group))); err != nil {
+			return m, err
+		}
+		m.Decisions = append(m.Decisions, part.Decisions...)
+		m.ActionItems = append(m.ActionItems, part.ActionItems...)
+		m.OpenQuestions = append(m.OpenQuestions, part.OpenQuestions...)
+	}
+
+	if _, err := io.WriteString(w, "\n\nMinutes:\n"); err != nil {
+		return m, fmt.Errorf("failed to write minutes: %w", err)
+	}
+	for _, e := range m.Decisions {
+		fmt.Fprintf(w, "- decision: %s\n", e.Description)
+	}
+	for _, e := range m.ActionItems {
+		fmt.Fprintf(w, "- action: %s (%s, %s)\n", e.Description, orDash(e.Owner), orDash(e.Due))
+	}
+	for _, e := range m.OpenQuestions {
+		fmt.Fprintf(w, "- question: %s\n", e.Description)
+	}
+	return m, flush(w)
+}
+
+// verify looks for the utterance of every entry in the transcripts, as
+// verifyQuotes does for the quotes. The entries are kept whether their
+// utterance is found or not: the decisions and tasks are paraphrased, only
+// their source is checked.
+func (m *minutes) verify(sources []source) {
+	for _, entries := range [][]minutesEntry{m.Decisions, m.ActionItems, m.OpenQuestions} {
+		for i := range entries {
+			e := &entries[i]
+			q := verifyQuotes([]quote{{Text: e.Utterance, File: e.File, Speaker: e.Speaker}}, sources, quotesFlag)[0]
+			e.Verified, e.File = q.Verified, q.File
+		}
+	}
+}
+
+// writeTasks exports the minutes to path: the action items as CSV if path
+// ends with .csv, the whole minutes as JSON otherwise.
+func (m minutes) writeTasks(path string) error {
+	f, err := createAtomic(path, false)
+	if err != nil {
+		return err
+	}
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		err = m.writeCSV(f)
+	} else {
+		err = m.writeJSON(f)
+	}
+	if err != nil {
+		f.Abort()
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return f.Commit()
+}
+
+// writeCSV writes the action items as CSV, with a header line.
+func (m minutes) writeCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"description", "owner", "due", "speaker", "file", "utterance", "verified"})
+	for _, e := range m.ActionItems {
+		cw.Write([]string{e.Description, e.Owner, e.Due, e.Speaker, e.File, e.Utterance, fmt.Sprint(e.Verified)})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeJSON writes the minutes as indented JSON.
+func (m minutes) writeJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(m)
+}
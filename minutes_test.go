@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testMinutes() minutes {
+	return minutes{
+		Decisions: []minutesEntry{
+			{Description: "Ship on Friday", Utterance: "ok, we ship on Friday", File: "chunk_000.m4a", Speaker: "Speaker A"},
+		},
+		ActionItems: []minutesEntry{
+			{Description: "Write the release notes", Owner: "Bob", Due: "Thursday", Utterance: "Bob, can you write the release notes by Thursday", File: "chunk_000.m4a", Speaker: "Speaker A"},
+			{Description: "Book a room", Utterance: "someone should book a room", File: "chunk_001.m4a"},
+		},
+		OpenQuestions: []minutesEntry{
+			{Description: "Which pricing?", Utterance: ""},
+		},
+	}
+}
+
+// TestMinutesVerify tests that the utterances are looked up in the transcripts
+func TestMinutesVerify(t *testing.T) {
+	m := testMinutes()
+	m.verify([]source{{Path: "/tmp/chunk_000.m4a", Transcript: "Speaker A: OK, we ship on Friday. Bob, can you write the release notes by Thursday?"}})
+	if !m.Decisions[0].Verified || m.Decisions[0].File != "chunk_000.m4a" {
+		t.Errorf("Expected the decision to be verified, got %+v", m.Decisions[0])
+	}
+	if !m.ActionItems[0].Verified || m.ActionItems[1].Verified || m.OpenQuestions[0].Verified {
+		t.Errorf("Unexpected verification: %+v, %+v", m.ActionItems, m.OpenQuestions)
+	}
+}
+
+// TestMinutesWriteTasks tests the CSV and JSON exports
+func TestMinutesWriteTasks(t *testing.T) {
+	dir := t.TempDir()
+	m := testMinutes()
+
+	path := filepath.Join(dir, "tasks.csv")
+	if err := m.writeTasks(path); err != nil {
+		t.Fatalf("writeTasks failed: %v", err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("failed to open export: %v", err)
+	}
+	defer f.Close()
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatalf("invalid CSV: %v", err)
+	}
+	if len(records) != 3 || records[0][0] != "description" || records[1][1] != "Bob" || records[1][2] != "Thursday" {
+		t.Errorf("Unexpected CSV: %q", records)
+	}
+
+	path = filepath.Join(dir, "tasks.json")
+	if err := m.writeTasks(path); err != nil {
+		t.Fatalf("writeTasks failed: %v", err)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read export: %v", err)
+	}
+	var back minutes
+	if err := json.Unmarshal(content, &back); err != nil || len(back.ActionItems) != 2 || back.Decisions[0].Description != "Ship on Friday" {
+		t.Errorf("Unexpected JSON export (%v):\n%s", err, content)
+	}
+}
+
+// TestDefaultReportTemplateMinutes tests the Minutes section
+func TestDefaultReportTemplateMinutes(t *testing.T) {
+	tmpl, err := loadReportTemplate("")
+	if err != nil {
+		t.Fatalf("failed to load default template: %v", err)
+	}
+	rep := testReport()
+	m := testMinutes()
+	m.ActionItems[0].Verified = true
+	rep.Minutes = &m
+	var buf bytes.Buffer
+	if err := rep.render(&buf, tmpl); err != nil {
+		t.Fatalf("render failed: %v", err)
+	}
+	for _, expected := range []string{
+		"## Minutes\n\n### Decisions\n\n- Ship on Friday\n  > ok, we ship on Friday (Speaker A, chunk_000.m4a, not found in the transcripts)\n",
+		"### Action items\n\n- [ ] Write the release notes (owner: Bob), due Thursday\n  > Bob, can you write the release notes by Thursday (Speaker A, chunk_000.m4a)\n- [ ] Book a room\n",
+		"### Open questions\n\n- Which pricing?\n",
+	} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("Expected report to contain %q, got:\n%s", expected, buf.String())
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// maxAttempts is the number of times a call is tried on a model before
+	// moving to the next model of the chain.
+	maxAttempts = 3
+)
+
+// retryDelay is the delay before the first retry; it doubles at every
+// attempt.
+var retryDelay = 2 * time.Second
+
+// parseModels parses a comma separated list of models.
+func parseModels(s string) []string {
+	var models []string
+	for _, model := range strings.Split(s, ",") {
+		if model = strings.TrimSpace(model); model != "" {
+			models = append(models, model)
+		}
+	}
+	return models
+}
+
+// models returns the model chain of a phase: GEMINI_TRANSCRIPTION_MODEL or
+// GEMINI_SYNTHESIS_MODEL if set, GEMINI_MODEL otherwise.
+func (c configuration) models(phase string) []string {
+	var models []string
+	switch phase {
+	case phaseTranscription:
+		models = parseModels(c.Transcription.Model)
+	case phaseSynthesis:
+		models = parseModels(c.Synthesis.Model)
+	}
+	if len(models) == 0 {
+		models = parseModels(c.GeminiModel)
+	}
+	return models
+}
+
+// withModels returns g using the first of the models and falling back to the
+// others.
+func (g generation) withModels(models []string) generation {
+	if len(models) == 0 {
+		return g
+	}
+	g.model = models[0]
+	g.fallbacks = models[1:]
+	return g
+}
+
+// isTransient reports whether err means the model is temporarily unable to
+// serve the request, e.g. overloaded or out of quota.
+func isTransient(err error) bool {
+	s, ok := status.FromError(err)
+	if !ok {
+		return false
+	}
+	switch s.Code() {
+	case codes.ResourceExhausted, codes.Unavailable, codes.DeadlineExceeded:
+		return true
+	}
+	return false
+}
+
+// isUnsupportedModel reports whether err means the model does not exist, is
+// deprecated or is not available in the region.
+func isUnsupportedModel(err error) bool {
+	s, ok := status.FromError(err)
+	if !ok {
+		return false
+	}
+	switch s.Code() {
+	case codes.NotFound:
+		return true
+	case codes.InvalidArgument, codes.FailedPrecondition:
+		msg := strings.ToLower(s.Message())
+		return strings.Contains(msg, "model") &&
+			(strings.Contains(msg, "not supported") || strings.Contains(msg, "unsupported") || strings.Contains(msg, "deprecated"))
+	}
+	return false
+}
+
+// withFallback calls f with g, retrying up to maxAttempts times when the
+// model is temporarily unavailable. If it is still unavailable, or if it is
+// not supported, f is called again with the next model of the chain. It
+// returns g set to the model that produced the result, or to the last model
+// tried.
+func (g generation) withFallback(f func(generation) error) (generation, error) {
+	models := append([]string{g.model}, g.fallbacks...)
+	var err error
+	for i, model := range models {
+		g.model, g.fallbacks = model, models[i+1:]
+		for attempt := 1; ; attempt++ {
+			err = f(g)
+			if err == nil || !isTransient(err) || attempt == maxAttempts {
+				break
+			}
+			delay := retryDelay << (attempt - 1)
+			logger.Warn("model unavailable, retrying", "model", model, "attempt", attempt, "delay", delay, "error", err)
+			time.Sleep(delay)
+		}
+		if err == nil || !(isTransient(err) || isUnsupportedModel(err)) {
+			return g, err
+		}
+		if i+1 < len(models) {
+			logger.Warn("moving to the next model of the chain", "model", model, "next", models[i+1], "error", err)
+		}
+	}
+	return g, err
+}
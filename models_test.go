@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// TestConfigurationModels tests the model chains of the phases
+func TestConfigurationModels(t *testing.T) {
+	config := configuration{
+		GeminiModel: "gemini-2.5-flash, gemini-2.0-flash,",
+		Synthesis:   phaseSettings{Model: "gemini-2.5-pro"},
+	}
+	if got := config.models(phaseTranscription); !reflect.DeepEqual(got, []string{"gemini-2.5-flash", "gemini-2.0-flash"}) {
+		t.Errorf("Expected the GEMINI_MODEL chain for the transcription, got %v", got)
+	}
+	if got := config.models(phaseSynthesis); !reflect.DeepEqual(got, []string{"gemini-2.5-pro"}) {
+		t.Errorf("Expected the synthesis model, got %v", got)
+	}
+
+	gen := generation{}.withModels(config.models(phaseTranscription))
+	if gen.model != "gemini-2.5-flash" || !reflect.DeepEqual(gen.fallbacks, []string{"gemini-2.0-flash"}) {
+		t.Errorf("Unexpected generation models: %q then %v", gen.model, gen.fallbacks)
+	}
+}
+
+// TestWithFallback tests the retries and the moves down the model chain
+func TestWithFallback(t *testing.T) {
+	delay := retryDelay
+	retryDelay = 0
+	t.Cleanup(func() { retryDelay = delay })
+
+	tests := []struct {
+		name   string
+		errs   map[string]error
+		model  string
+		calls  []string
+		failed bool
+	}{
+		{
+			name:  "first model",
+			errs:  map[string]error{},
+			model: "a",
+			calls: []string{"a"},
+		},
+		{
+			name:  "retry exhausted",
+			errs:  map[string]error{"a": status.Error(codes.ResourceExhausted, "quota")},
+			model: "b",
+			calls: []string{"a", "a", "a", "b"},
+		},
+		{
+			name:  "unsupported model",
+			errs:  map[string]error{"a": status.Error(codes.NotFound, "Publisher model a was not found")},
+			model: "b",
+			calls: []string{"a", "b"},
+		},
+		{
+			name:   "other error",
+			errs:   map[string]error{"a": errors.New("invalid audio")},
+			model:  "a",
+			calls:  []string{"a"},
+			failed: true,
+		},
+		{
+			name:   "chain exhausted",
+			errs:   map[string]error{"a": status.Error(codes.NotFound, "not found"), "b": status.Error(codes.NotFound, "not found")},
+			model:  "b",
+			calls:  []string{"a", "b"},
+			failed: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls []string
+			gen, err := generation{}.withModels([]string{"a", "b"}).withFallback(func(gen generation) error {
+				calls = append(calls, gen.model)
+				return tt.errs[gen.model]
+			})
+			if (err != nil) != tt.failed {
+				t.Errorf("Unexpected error: %v", err)
+			}
+			if gen.model != tt.model {
+				t.Errorf("Expected model %q, got %q", tt.model, gen.model)
+			}
+			if !reflect.DeepEqual(calls, tt.calls) {
+				t.Errorf("Expected calls %v, got %v", tt.calls, calls)
+			}
+		})
+	}
+}
+
+// TestIsUnsupportedModel tests the classification of the model errors
+func TestIsUnsupportedModel(t *testing.T) {
+	if !isUnsupportedModel(fmt.Errorf("unable to generate contents: %w", status.Error(codes.InvalidArgument, "Model gemini-1.0-pro is deprecated"))) {
+		t.Error("Expected a wrapped deprecated model error to be unsupported")
+	}
+	if isUnsupportedModel(status.Error(codes.InvalidArgument, "invalid audio")) {
+		t.Error("Expected an invalid argument unrelated to the model to be kept")
+	}
+	if !isTransient(fmt.Errorf("unable to generate contents: %w", status.Error(codes.Unavailable, "overloaded"))) {
+		t.Error("Expected a wrapped unavailable error to be transient")
+	}
+}
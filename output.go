@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// atomicFile is a temporary file living next to its final destination.
+// Nothing is visible at the destination until Commit is called, so a crash
+// halfway through a run never truncates an existing report.
+type atomicFile struct {
+	*os.File
+	path string
+	mode fs.FileMode
+	// existing is the number of bytes copied from the previous report in
+	// append mode.
+	existing int64
+}
+
+// createAtomic creates a temporary file in the same directory as path.
+// In append mode, the current content of path (if any) is copied first so
+// that new writes are added after it.
+func createAtomic(path string, appendMode bool) (*atomicFile, error) {
+	a := &atomicFile{path: path, mode: 0o644}
+	if fi, err := os.Stat(path); err == nil {
+		a.mode = fi.Mode().Perm()
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return nil, fmt.Errorf("os.CreateTemp: %w", err)
+	}
+	a.File = tmp
+
+	if appendMode {
+		src, err := os.Open(path)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			// Nothing to append to yet.
+		case err != nil:
+			a.Abort()
+			return nil, fmt.Errorf("os.Open: %w", err)
+		default:
+			a.existing, err = io.Copy(tmp, src)
+			src.Close()
+			if err != nil {
+				a.Abort()
+				return nil, fmt.Errorf("io.Copy: %w", err)
+			}
+		}
+	}
+
+	return a, nil
+}
+
+// Commit syncs the temporary file to disk and renames it over the destination.
+func (a *atomicFile) Commit() error {
+	if err := a.Chmod(a.mode); err != nil {
+		a.Abort()
+		return fmt.Errorf("File.Chmod: %w", err)
+	}
+	if err := a.Sync(); err != nil {
+		a.Abort()
+		return fmt.Errorf("File.Sync: %w", err)
+	}
+	if err := a.Close(); err != nil {
+		os.Remove(a.Name())
+		return fmt.Errorf("File.Close: %w", err)
+	}
+	if err := os.Rename(a.Name(), a.path); err != nil {
+		os.Remove(a.Name())
+		return fmt.Errorf("os.Rename: %w", err)
+	}
+
+	// Persist the rename itself; not every platform supports syncing a
+	// directory, so this is best effort.
+	if dir, err := os.Open(filepath.Dir(a.path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	return nil
+}
+
+// rewrite replaces the content written so far by update(content), for
+// instance to refresh the front matter of the report appended to. The next
+// writes are added at the end.
+func (a *atomicFile) rewrite(update func([]byte) []byte) error {
+	content, err := os.ReadFile(a.Name())
+	if err != nil {
+		return fmt.Errorf("os.ReadFile: %w", err)
+	}
+	if err := a.Truncate(0); err != nil {
+		return fmt.Errorf("File.Truncate: %w", err)
+	}
+	if _, err := a.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("File.Seek: %w", err)
+	}
+	if _, err := a.Write(update(content)); err != nil {
+		return fmt.Errorf("File.Write: %w", err)
+	}
+	return nil
+}
+
+// Abort discards the temporary file and leaves the destination untouched.
+func (a *atomicFile) Abort() error {
+	a.Close()
+	return os.Remove(a.Name())
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestAtomicFileCommit tests that the destination only changes on Commit
+func TestAtomicFileCommit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report.md")
+	if err := os.WriteFile(path, []byte("yesterday's report"), 0o644); err != nil {
+		t.Fatalf("failed to seed report: %v", err)
+	}
+
+	out, err := createAtomic(path, false)
+	if err != nil {
+		t.Fatalf("createAtomic failed: %v", err)
+	}
+	io.WriteString(out, "today's report")
+
+	content, _ := os.ReadFile(path)
+	if string(content) != "yesterday's report" {
+		t.Errorf("destination modified before commit: %q", content)
+	}
+
+	if err := out.Commit(); err != nil {
+		t.Fatalf("commit failed: %v", err)
+	}
+	content, _ = os.ReadFile(path)
+	if string(content) != "today's report" {
+		t.Errorf("Expected committed content, got: %q", content)
+	}
+	if _, err := os.Stat(out.Name()); !os.IsNotExist(err) {
+		t.Errorf("temporary file still present after commit: %v", err)
+	}
+}
+
+// TestAtomicFileAbort tests that an aborted run leaves the previous report intact
+func TestAtomicFileAbort(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "report.md")
+	if err := os.WriteFile(path, []byte("yesterday's report"), 0o644); err != nil {
+		t.Fatalf("failed to seed report: %v", err)
+	}
+
+	out, err := createAtomic(path, false)
+	if err != nil {
+		t.Fatalf("createAtomic failed: %v", err)
+	}
+	io.WriteString(out, "half a rep")
+	out.Abort()
+
+	content, _ := os.ReadFile(path)
+	if string(content) != "yesterday's report" {
+		t.Errorf("destination modified by aborted run: %q", content)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("Expected only the report in %s, got %d entries", dir, len(entries))
+	}
+}
+
+// TestAtomicFileAppend tests that append mode keeps the existing content
+func TestAtomicFileAppend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report.md")
+	if err := os.WriteFile(path, []byte("first run\n"), 0o600); err != nil {
+		t.Fatalf("failed to seed report: %v", err)
+	}
+
+	out, err := createAtomic(path, true)
+	if err != nil {
+		t.Fatalf("createAtomic failed: %v", err)
+	}
+	if out.existing != int64(len("first run\n")) {
+		t.Errorf("Expected %d existing bytes, got %d", len("first run\n"), out.existing)
+	}
+	io.WriteString(out, "second run\n")
+	if err := out.Commit(); err != nil {
+		t.Fatalf("commit failed: %v", err)
+	}
+
+	content, _ := os.ReadFile(path)
+	if string(content) != "first run\nsecond run\n" {
+		t.Errorf("Unexpected appended content: %q", content)
+	}
+	fi, _ := os.Stat(path)
+	if fi.Mode().Perm() != 0o600 {
+		t.Errorf("Expected permissions to be preserved, got %v", fi.Mode().Perm())
+	}
+}
+
+// TestAtomicFileAppendMissing tests append mode on a report that does not exist yet
+func TestAtomicFileAppendMissing(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report.md")
+
+	out, err := createAtomic(path, true)
+	if err != nil {
+		t.Fatalf("createAtomic failed: %v", err)
+	}
+	if out.existing != 0 {
+		t.Errorf("Expected no existing content, got %d bytes", out.existing)
+	}
+	io.WriteString(out, "new report")
+	if err := out.Commit(); err != nil {
+		t.Fatalf("commit failed: %v", err)
+	}
+
+	content, _ := os.ReadFile(path)
+	if string(content) != "new report" {
+		t.Errorf("Unexpected content: %q", content)
+	}
+}
+
+// TestAtomicFileRewrite tests that the content written so far can be replaced before the next writes
+func TestAtomicFileRewrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report.md")
+	if err := os.WriteFile(path, []byte("sources: 1\nfirst run\n"), 0o644); err != nil {
+		t.Fatalf("failed to seed report: %v", err)
+	}
+
+	out, err := createAtomic(path, true)
+	if err != nil {
+		t.Fatalf("createAtomic failed: %v", err)
+	}
+	io.WriteString(out, "second run\n")
+	if err := out.rewrite(func(content []byte) []byte {
+		return []byte(strings.Replace(string(content), "sources: 1", "sources: 2", 1))
+	}); err != nil {
+		t.Fatalf("rewrite failed: %v", err)
+	}
+	io.WriteString(out, "end\n")
+	if err := out.Commit(); err != nil {
+		t.Fatalf("commit failed: %v", err)
+	}
+
+	content, _ := os.ReadFile(path)
+	if string(content) != "sources: 2\nfirst run\nsecond run\nend\n" {
+		t.Errorf("Unexpected rewritten content: %q", content)
+	}
+}
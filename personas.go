@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"cloud.google.com/go/vertexai/genai"
+)
+
+// personasNote is added to the personas prompt of the profile so that
+// every element can be attributed to the transcripts.
+const personasNote = `
+
+Every goal, frustration, behavior and pain point must come with the quotes of the transcripts it is drawn from. Copy every quote word for word from a single transcript, with the file of the transcript as in its <transcript file="..."> tag and the speaker as labelled in the transcript (an empty string if unknown). Do not invent a persona or a stage the transcripts do not support.`
+
+// attributedSchema is the schema of an element of a persona or of the
+// journey map and the quotes it is drawn from.
+var attributedSchema = &genai.Schema{
+	Type: genai.TypeArray,
+	Items: &genai.Schema{
+		Type: genai.TypeObject,
+		Properties: map[string]*genai.Schema{
+			"text":    {Type: genai.TypeString},
+			"sources": quotesSchema,
+		},
+		Required: []string{"text", "sources"},
+	},
+}
+
+// personasSchema is the schema of the response to the personas prompt.
+var personasSchema = &genai.Schema{
+	Type: genai.TypeObject,
+	Properties: map[string]*genai.Schema{
+		"personas": {
+			Type: genai.TypeArray,
+			Items: &genai.Schema{
+				Type: genai.TypeObject,
+				Properties: map[string]*genai.Schema{
+					"name":         {Type: genai.TypeString},
+					"description":  {Type: genai.TypeString},
+					"interviews":   {Type: genai.TypeArray, Items: &genai.Schema{Type: genai.TypeString}},
+					"goals":        attributedSchema,
+					"frustrations": attributedSchema,
+					"behaviors":    attributedSchema,
+					"quotes":       quotesSchema,
+				},
+				Required: []string{"name", "description", "interviews", "goals", "frustrations", "behaviors", "quotes"},
+			},
+		},
+		"journey": {
+			Type: genai.TypeArray,
+			Items: &genai.Schema{
+				Type: genai.TypeObject,
+				Properties: map[string]*genai.Schema{
+					"stage":       {Type: genai.TypeString},
+					"description": {Type: genai.TypeString},
+					"sources":     quotesSchema,
+					"pain_points": attributedSchema,
+				},
+				Required: []string{"stage", "description", "sources", "pain_points"},
+			},
+		},
+	},
+	Required: []string{"personas", "journey"},
+}
+
+// personaSet are the draft personas and the journey map of interviews.
+type personaSet struct {
+	Personas []persona      `json:"personas"`
+	Journey  []journeyStage `json:"journey"`
+}
+
+// persona is a draft persona, drawn from the Interviews.
+type persona struct {
+	Name         string       `json:"name"`
+	Description  string       `json:"description"`
+	Interviews   []string     `json:"interviews"`
+	Goals        []attributed `json:"goals"`
+	Frustrations []attributed `json:"frustrations"`
+	Behaviors    []attributed `json:"behaviors"`
+	Quotes       []quote      `json:"quotes"`
+}
+
+// journeyStage is a stage of the journey map, illustrated by the Sources.
+type journeyStage struct {
+	Stage       string       `json:"stage"`
+	Description string       `json:"description"`
+	Sources     []quote      `json:"sources"`
+	PainPoints  []attributed `json:"pain_points"`
+}
+
+// Interviews returns the files of the quotes of the stage and of its pain
+// points, without duplicates.
+func (s journeyStage) Interviews() []string {
+	var files []string
+	add := func(quotes []quote) {
+		for _, q := range quotes {
+			if q.File != "" && !containsFold(files, q.File) {
+				files = append(files, q.File)
+			}
+		}
+	}
+	add(s.Sources)
+	for _, p := range s.PainPoints {
+		add(p.Sources)
+	}
+	return files
+}
+
+// attributed is a goal, frustration, behavior or pain point and the quotes
+// it is drawn from.
+type attributed struct {
+	Text    string  `json:"text"`
+	Sources []quote `json:"sources"`
+}
+
+// draftPersonas asks the model for the personas and the journey map of the
+// sources, framed by the prompt of the profile, and writes them to w. The
+// synthesis, if any, is given as a starting point. The transcripts are sent
+// in a single call since the personas are drawn from all the interviews
+// together: if they exceed tokenLimit tokens with the prompt, a warning is
+// logged and no personas are returned, so that the run keeps its report.
+func draftPersonas(w io.Writer, gen generation, prompt, systemInstruction, synthesis string, sources []source, tokenLimit int32) (*personaSet, error) {
+	ctx := context.Background()
+	client, err := genai.NewClient(ctx, gen.projectID, gen.location)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create client: %w", err)
+	}
+	defer client.Close()
+
+	parts := []genai.Part{genai.Text(prompt + personasNote)}
+	if synthesis != "" {
+		parts = append(parts, genai.Text("Synthesis of the interviews, as a starting point:\n"+synthesis))
+	}
+	j := jsonGenerator{client: client, gen: gen, systemInstruction: systemInstruction, schema: personasSchema, phase: phasePersonas}
+	_, tokens := j.groups(ctx, sources, tokenLimit, parts...)
+	if tokens > tokenLimit {
+		logger.Warn("the transcripts exceed -summary-token-limit, skipping the personas: draft them on fewer interviews, or raise the limit if the model accepts it", "tokens", tokens, "limit", tokenLimit)
+		return nil, nil
+	}
+
+	var p personaSet
+	if err := j.generate(ctx, tokens, &p, append(parts, genai.Text(formatCorpus(sources)))...); err != nil {
+		return nil, err
+	}
+
+	if _, err := io.WriteString(w, "\n\nPersonas:\n"); err != nil {
+		return &p, fmt.Errorf("failed to write personas: %w", err)
+	}
+	for _, persona := range p.Personas {
+		fmt.Fprintf(w, "- %s (%s)\n", persona.Name, strings.Join(persona.Interviews, ", "))
+	}
+	if _, err := io.WriteString(w, "\nJourney:\n"); err != nil {
+		return &p, fmt.Errorf("failed to write journey: %w", err)
+	}
+	for _, stage := range p.Journey {
+		fmt.Fprintf(w, "- %s: %d pain points\n", stage.Stage, len(stage.PainPoints))
+	}
+	return &p, flush(w)
+}
+
+// verify looks for every quote of the personas and the journey map in the
+// transcripts, as verifyQuotes does, and keeps the interviews of the
+// personas that are among the sources. The elements are kept whether their
+// quotes are found or not; only their source is checked.
+func (p *personaSet) verify(sources []source) {
+	for i := range p.Personas {
+		persona := &p.Personas[i]
+		var interviews []string
+		for _, file := range persona.Interviews {
+			if src, ok := findSource(sources, file); ok {
+				interviews = append(interviews, src.label())
+			} else {
+				logger.Warn("ignoring an interview of a persona not in the transcripts", "persona", persona.Name, "file", file)
+			}
+		}
+		persona.Interviews = interviews
+		for _, list := range [][]attributed{persona.Goals, persona.Frustrations, persona.Behaviors} {
+			verifyAttributed(list, sources)
+		}
+		persona.Quotes = verifyQuotes(persona.Quotes, sources, quotesFlag)
+	}
+	for i := range p.Journey {
+		stage := &p.Journey[i]
+		stage.Sources = verifyQuotes(stage.Sources, sources, quotesFlag)
+		verifyAttributed(stage.PainPoints, sources)
+	}
+}
+
+// verifyAttributed looks for the quotes of the elements in the transcripts.
+func verifyAttributed(list []attributed, sources []source) {
+	for i := range list {
+		list[i].Sources = verifyQuotes(list[i].Sources, sources, quotesFlag)
+	}
+}
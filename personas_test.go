@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestPersonaSetVerify tests that the quotes of every element are looked up and the unknown interviews dropped
+func TestPersonaSetVerify(t *testing.T) {
+	sources := []source{
+		{Path: "/tmp/interview1.m4a", Transcript: "Speaker A: honestly the checkout is way too slow on my phone"},
+		{Path: "/tmp/interview2.m4a", Transcript: "Speaker B: I always compare prices before buying"},
+	}
+	p := &personaSet{
+		Personas: []persona{{
+			Name:       "The bargain hunter",
+			Interviews: []string{"interview2.m4a", " interview9.m4a"},
+			Behaviors: []attributed{{Text: "Compares prices", Sources: []quote{
+				{Text: "I always compare prices before buying", File: "interview2.m4a", Speaker: "Speaker B"},
+			}}},
+			Quotes: []quote{{Text: "I never buy at full price", File: "interview2.m4a"}},
+		}},
+		Journey: []journeyStage{{
+			Stage:   "Checkout",
+			Sources: []quote{{Text: "the checkout is way too slow", File: "interview1.m4a"}},
+			PainPoints: []attributed{{Text: "Slow on mobile", Sources: []quote{
+				{Text: "way too slow on my phone", File: "interview2.m4a", Speaker: "Speaker A"},
+			}}},
+		}},
+	}
+	p.verify(sources)
+
+	persona := p.Personas[0]
+	if !reflect.DeepEqual(persona.Interviews, []string{"interview2.m4a"}) {
+		t.Errorf("Interviews = %v, expected the interviews among the sources", persona.Interviews)
+	}
+	if q := persona.Behaviors[0].Sources[0]; !q.Verified {
+		t.Errorf("Expected the quote of the behavior to be verified: %+v", q)
+	}
+	if q := persona.Quotes[0]; q.Verified {
+		t.Errorf("Expected the invented quote to be flagged: %+v", q)
+	}
+	stage := p.Journey[0]
+	if q := stage.PainPoints[0].Sources[0]; !q.Verified || q.File != "interview1.m4a" {
+		t.Errorf("Expected the quote of the pain point to be attributed to its transcript: %+v", q)
+	}
+	if files := stage.Interviews(); !reflect.DeepEqual(files, []string{"interview1.m4a"}) {
+		t.Errorf("Interviews() = %v, expected [interview1.m4a]", files)
+	}
+}
+
+// TestDefaultReportTemplatePersonas tests the rendering of the personas and the journey map with their sources
+func TestDefaultReportTemplatePersonas(t *testing.T) {
+	tmpl, err := loadReportTemplate("")
+	if err != nil {
+		t.Fatalf("failed to load default template: %v", err)
+	}
+	rep := testReport()
+	rep.Personas = &personaSet{
+		Personas: []persona{{
+			Name:        "The bargain hunter",
+			Description: "Compares prices everywhere.",
+			Interviews:  []string{"chunk_000.m4a", "chunk_001.m4a"},
+			Goals: []attributed{{Text: "Pay less", Sources: []quote{
+				{Text: "cheaper", File: "chunk_000.m4a", Speaker: "Speaker A", Verified: true},
+				{Text: "deals", File: "chunk_001.m4a"},
+			}}},
+			Quotes: []quote{{Text: "never at full price", File: "chunk_001.m4a", Speaker: "Speaker B", Verified: true}},
+		}},
+		Journey: []journeyStage{{
+			Stage:       "Checkout",
+			Description: "Paying for the order.",
+			Sources:     []quote{{Text: "then I pay", File: "chunk_000.m4a", Verified: true}},
+			PainPoints: []attributed{{Text: "Slow on mobile", Sources: []quote{
+				{Text: "way too slow", File: "chunk_001.m4a", Speaker: "Speaker B", Verified: true},
+			}}},
+		}},
+	}
+	var buf bytes.Buffer
+	if err := rep.render(&buf, tmpl); err != nil {
+		t.Fatalf("render failed: %v", err)
+	}
+	if strings.Contains(buf.String(), "\n\n\n") {
+		t.Errorf("Unexpected blank lines in report:\n%s", buf.String())
+	}
+	for _, expected := range []string{
+		"## Personas\n\n### The bargain hunter\n\nCompares prices everywhere.\n\nDrawn from: chunk_000.m4a, chunk_001.m4a\n\n" +
+			"**Goals**\n\n- Pay less\n  > \"cheaper\" (Speaker A, chunk_000.m4a)\n  > \"deals\" (chunk_001.m4a, not found in the transcripts)\n\n" +
+			"**Quotes**\n\n- \"never at full price\" (Speaker B, chunk_001.m4a)\n",
+		"## Journey map\n\n| Stage | Pain points | Interviews |\n|-------|-------------|------------|\n| Checkout | 1 | chunk_000.m4a, chunk_001.m4a |\n\n" +
+			"### Checkout\n\nPaying for the order.\n\n> \"then I pay\" (chunk_000.m4a)\n\n**Pain points**\n\n- Slow on mobile\n  > \"way too slow\" (Speaker B, chunk_001.m4a)\n",
+	} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("Expected report to contain %q, got:\n%s", expected, buf.String())
+		}
+	}
+}
@@ -1,33 +1,139 @@
 package main
 
+import (
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
 const (
-	// TranscriptionPrompt is the prompt used for audio transcription
-	TranscriptionPrompt = `Transcribe this audio interview accurately. Follow these guidelines:
-
-1. Format: Speaker: [spoken content]
-2. Use "Speaker A", "Speaker B", etc. to identify different speakers
-3. DO NOT include timestamps or timecodes
-4. Focus on complete, meaningful sentences - avoid fragmentary repetitions
-5. If you hear repetitive words (like "yes yes yes"), transcribe it only once unless the repetition is clearly intentional and meaningful
-6. Capture the essence of what is being said, not every single utterance
-7. If there are unclear sections, use [unclear] rather than guessing or repeating
-8. Maintain natural conversation flow and avoid artificial line breaks
-
-Provide a clean, readable transcript without timestamps.`
-
-	// SummaryPrompt is the prompt used for post-processing and summarization
-	SummaryPrompt = `The context is about designing a new eCommerce platform.
-
-	Create a comprehensive summary of these interview transcripts. Follow these guidelines:
-
-1. Extract the main ideas, key insights, and solutions discussed
-2. Structure the output using markdown formatting
-3. Generate the summary in the same language as the original content
-4. If multiple audio files are provided, identify common themes and insights across all files
-5. Include a "Key Takeaways" section with the most important points
-6. Add a "Potential Issues/Pitfalls" section at the end if any concerns were identified
-7. Organize content logically with clear headings and bullet points
-8. Focus on actionable insights and concrete information
-
-Provide a well-structured, comprehensive analysis of the content.`
+	// defaultProfile is the name of the profile used when none is given.
+	defaultProfile = "default"
+
+	transcriptionPromptFile = "transcription.md"
+	summaryPromptFile       = "summary.md"
+	personasPromptFile      = "personas.md"
 )
+
+// builtinProfiles holds the profiles shipped with the binary. The default
+// profile must define every prompt, other profiles fall back to it.
+//
+//go:embed profiles
+var builtinProfiles embed.FS
+
+// promptData holds the variables available in the prompt templates.
+type promptData struct {
+	// ProjectContext describes what the recordings are about.
+	ProjectContext string
+	// Language is the language of the recordings and of the summary.
+	// If empty, the model uses the language of the content.
+	Language string
+	// Speakers is the expected number of speakers, 0 if unknown.
+	Speakers int
+}
+
+// prompts holds the rendered prompts of a profile.
+type prompts struct {
+	Profile       string
+	Transcription string
+	Summary       string
+	// Personas frames the personas and the journey map, see -personas.
+	Personas string
+}
+
+// version identifies the prompts used for a run. It changes whenever the
+// wording of one of the prompts changes.
+func (p prompts) version() string {
+	h := sha256.Sum256([]byte(p.Transcription + p.Summary + p.Personas))
+	return p.Profile + "-" + hex.EncodeToString(h[:4])
+}
+
+// loadPrompts renders the prompts of the named profile. The profile is
+// looked up as a directory path, then in the user configuration directory
+// (audiotranscribe/profiles/<name>), then among the built-in profiles.
+// transcriptionFile and summaryFile, when set, override the prompts of the
+// profile.
+func loadPrompts(profile, transcriptionFile, summaryFile string, data promptData) (prompts, error) {
+	if profile == "" {
+		profile = defaultProfile
+	}
+	fsys, err := profileFS(profile)
+	if err != nil {
+		return prompts{}, err
+	}
+
+	p := prompts{Profile: filepath.Base(profile)}
+	p.Transcription, err = renderPrompt(fsys, transcriptionPromptFile, transcriptionFile, data)
+	if err != nil {
+		return prompts{}, err
+	}
+	p.Summary, err = renderPrompt(fsys, summaryPromptFile, summaryFile, data)
+	if err != nil {
+		return prompts{}, err
+	}
+	p.Personas, err = renderPrompt(fsys, personasPromptFile, "", data)
+	if err != nil {
+		return prompts{}, err
+	}
+	return p, nil
+}
+
+// profileFS returns the directory holding the prompts of the profile.
+func profileFS(profile string) (fs.FS, error) {
+	if fi, err := os.Stat(profile); err == nil && fi.IsDir() {
+		return os.DirFS(profile), nil
+	}
+	if strings.ContainsRune(profile, filepath.Separator) {
+		return nil, fmt.Errorf("profile directory %q not found", profile)
+	}
+	if dir, err := os.UserConfigDir(); err == nil {
+		userProfile := filepath.Join(dir, "audiotranscribe", "profiles", profile)
+		if fi, err := os.Stat(userProfile); err == nil && fi.IsDir() {
+			return os.DirFS(userProfile), nil
+		}
+	}
+	fsys, err := fs.Sub(builtinProfiles, "profiles/"+profile)
+	if err != nil {
+		return nil, fmt.Errorf("fs.Sub: %w", err)
+	}
+	if _, err := fs.Stat(fsys, "."); err != nil {
+		return nil, fmt.Errorf("unknown profile %q", profile)
+	}
+	return fsys, nil
+}
+
+// renderPrompt renders the prompt from overrideFile if set, else from name
+// in the profile, else from name in the default profile.
+func renderPrompt(fsys fs.FS, name, overrideFile string, data promptData) (string, error) {
+	var content []byte
+	var err error
+	switch {
+	case overrideFile != "":
+		content, err = os.ReadFile(overrideFile)
+	default:
+		content, err = fs.ReadFile(fsys, name)
+		if errors.Is(err, fs.ErrNotExist) {
+			content, err = builtinProfiles.ReadFile("profiles/" + defaultProfile + "/" + name)
+		}
+	}
+	if err != nil {
+		return "", fmt.Errorf("unable to read prompt %s: %w", name, err)
+	}
+
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(string(content))
+	if err != nil {
+		return "", fmt.Errorf("unable to parse prompt %s: %w", name, err)
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("unable to render prompt %s: %w", name, err)
+	}
+	return strings.TrimSpace(b.String()), nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// legacyTranscriptionPrompt and legacySummaryPrompt are the prompts that were
+// compiled in before profiles existed. The default profile must render them
+// unchanged when no variable is set.
+const (
+	legacyTranscriptionPrompt = `Transcribe this audio interview accurately. Follow these guidelines:
+
+1. Format: Speaker: [spoken content]
+2. Use "Speaker A", "Speaker B", etc. to identify different speakers
+3. DO NOT include timestamps or timecodes
+4. Focus on complete, meaningful sentences - avoid fragmentary repetitions
+5. If you hear repetitive words (like "yes yes yes"), transcribe it only once unless the repetition is clearly intentional and meaningful
+6. Capture the essence of what is being said, not every single utterance
+7. If there are unclear sections, use [unclear] rather than guessing or repeating
+8. Maintain natural conversation flow and avoid artificial line breaks
+
+Provide a clean, readable transcript without timestamps.`
+
+	legacySummaryPrompt = `The context is about designing a new eCommerce platform.
+
+	Create a comprehensive summary of these interview transcripts. Follow these guidelines:
+
+1. Extract the main ideas, key insights, and solutions discussed
+2. Structure the output using markdown formatting
+3. Generate the summary in the same language as the original content
+4. If multiple audio files are provided, identify common themes and insights across all files
+5. Include a "Key Takeaways" section with the most important points
+6. Add a "Potential Issues/Pitfalls" section at the end if any concerns were identified
+7. Organize content logically with clear headings and bullet points
+8. Focus on actionable insights and concrete information
+
+Provide a well-structured, comprehensive analysis of the content.`
+)
+
+// TestDefaultProfile tests that the default profile matches the legacy prompts
+func TestDefaultProfile(t *testing.T) {
+	p, err := loadPrompts("", "", "", promptData{})
+	if err != nil {
+		t.Fatalf("failed to load default profile: %v", err)
+	}
+	if p.Profile != defaultProfile {
+		t.Errorf("Expected profile %q, got %q", defaultProfile, p.Profile)
+	}
+	if p.Transcription != legacyTranscriptionPrompt {
+		t.Errorf("Transcription prompt mismatch.\nExpected:\n%s\nGot:\n%s", legacyTranscriptionPrompt, p.Transcription)
+	}
+	if p.Summary != legacySummaryPrompt {
+		t.Errorf("Summary prompt mismatch.\nExpected:\n%s\nGot:\n%s", legacySummaryPrompt, p.Summary)
+	}
+}
+
+// TestPromptVariables tests the template variables of the default profile
+func TestPromptVariables(t *testing.T) {
+	p, err := loadPrompts(defaultProfile, "", "", promptData{
+		ProjectContext: "redesigning the loyalty program",
+		Language:       "French",
+		Speakers:       3,
+	})
+	if err != nil {
+		t.Fatalf("failed to load default profile: %v", err)
+	}
+
+	if strings.Contains(p.Summary, "eCommerce") {
+		t.Errorf("Project context did not replace the default one:\n%s", p.Summary)
+	}
+	if !strings.Contains(p.Summary, "The context is about redesigning the loyalty program.") {
+		t.Errorf("Project context missing from summary prompt:\n%s", p.Summary)
+	}
+	if !strings.Contains(p.Summary, "Generate the summary in French") {
+		t.Errorf("Language missing from summary prompt:\n%s", p.Summary)
+	}
+	if !strings.Contains(p.Transcription, "(there are 3 speakers in this recording)") {
+		t.Errorf("Speaker count missing from transcription prompt:\n%s", p.Transcription)
+	}
+}
+
+// TestPersonasPrompt tests that the personas prompt is framed by the profile and the project context
+func TestPersonasPrompt(t *testing.T) {
+	p, err := loadPrompts(defaultProfile, "", "", promptData{ProjectContext: "the onboarding of a banking app"})
+	if err != nil {
+		t.Fatalf("failed to load default profile: %v", err)
+	}
+	if strings.Contains(p.Personas, "eCommerce") || strings.Contains(p.Personas, "purchase") {
+		t.Errorf("Expected a personas prompt without the framing of a project:\n%s", p.Personas)
+	}
+	if !strings.HasPrefix(p.Personas, "The context of this study is the onboarding of a banking app.") {
+		t.Errorf("Project context missing from the personas prompt:\n%s", p.Personas)
+	}
+
+	p, err = loadPrompts("customer-interview", "", "", promptData{})
+	if err != nil {
+		t.Fatalf("failed to load profile: %v", err)
+	}
+	if !strings.Contains(p.Personas, "journey map of the customers") {
+		t.Errorf("Expected the customer-interview personas prompt, got:\n%s", p.Personas)
+	}
+}
+
+// TestBuiltinProfileFallback tests that a profile without a transcription prompt uses the default one
+func TestBuiltinProfileFallback(t *testing.T) {
+	p, err := loadPrompts("customer-interview", "", "", promptData{})
+	if err != nil {
+		t.Fatalf("failed to load profile: %v", err)
+	}
+	if p.Transcription != legacyTranscriptionPrompt {
+		t.Errorf("Expected the default transcription prompt, got:\n%s", p.Transcription)
+	}
+	if !strings.Contains(p.Summary, "customer interviews") {
+		t.Errorf("Expected the customer-interview summary prompt, got:\n%s", p.Summary)
+	}
+}
+
+// TestProfileDirectory tests loading a profile from a directory and overriding a prompt with a file
+func TestProfileDirectory(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "workshop")
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatalf("failed to create profile: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, summaryPromptFile), []byte("Summarize the workshop about {{ .ProjectContext }}.\n"), 0o644); err != nil {
+		t.Fatalf("failed to write prompt: %v", err)
+	}
+	override := filepath.Join(t.TempDir(), "transcription.txt")
+	if err := os.WriteFile(override, []byte("Transcribe in {{ .Language }}."), 0o644); err != nil {
+		t.Fatalf("failed to write prompt: %v", err)
+	}
+
+	p, err := loadPrompts(dir, override, "", promptData{ProjectContext: "pricing", Language: "German"})
+	if err != nil {
+		t.Fatalf("failed to load profile: %v", err)
+	}
+	if p.Profile != "workshop" {
+		t.Errorf("Expected profile name %q, got %q", "workshop", p.Profile)
+	}
+	if p.Summary != "Summarize the workshop about pricing." {
+		t.Errorf("Unexpected summary prompt: %q", p.Summary)
+	}
+	if p.Transcription != "Transcribe in German." {
+		t.Errorf("Unexpected transcription prompt: %q", p.Transcription)
+	}
+}
+
+// TestUnknownProfile tests that an unknown profile is reported
+func TestUnknownProfile(t *testing.T) {
+	if _, err := loadPrompts("no-such-profile", "", "", promptData{}); err == nil {
+		t.Error("Expected an error for an unknown profile")
+	}
+}
+
+// TestPromptVersion tests that the version changes with the prompts
+func TestPromptVersion(t *testing.T) {
+	a := prompts{Profile: "default", Transcription: "a", Summary: "b"}
+	b := prompts{Profile: "default", Transcription: "a", Summary: "c"}
+	if a.version() == b.version() {
+		t.Errorf("Expected different versions, got %q for both", a.version())
+	}
+	if !strings.HasPrefix(a.version(), "default-") {
+		t.Errorf("Expected version to start with the profile name, got %q", a.version())
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+
+	"cloud.google.com/go/vertexai/genai"
+)
+
+// Modes of the verification of the quotes, see -quotes.
+const (
+	quotesFlag = "flag"
+	quotesDrop = "drop"
+)
+
+// quoteMatchThreshold is the similarity above which a quote is considered
+// as found in a transcript.
+const quoteMatchThreshold = 0.85
+
+// quotesPrompt asks for the quotes supporting the synthesis.
+const quotesPrompt = `Select the key verbatim quotes of the transcripts below that support the synthesis below: about 2 to 4 per theme of the synthesis.
+
+1. Copy every quote word for word from a single transcript, without fixing the grammar, translating or merging sentences
+2. Give the file of the transcript the quote comes from, as in its <transcript file="..."> tag
+3. Give the speaker as labelled in the transcript, or an empty string if unknown`
+
+// quotesSchema is the schema of the response to quotesPrompt.
+var quotesSchema = &genai.Schema{
+	Type: genai.TypeArray,
+	Items: &genai.Schema{
+		Type: genai.TypeObject,
+		Properties: map[string]*genai.Schema{
+			"quote":   {Type: genai.TypeString},
+			"file":    {Type: genai.TypeString},
+			"speaker": {Type: genai.TypeString},
+		},
+		Required: []string{"quote", "file", "speaker"},
+	},
+}
+
+// quote is a quote of a transcript selected by the model.
+type quote struct {
+	Text    string `json:"quote"`
+	File    string `json:"file"`
+	Speaker string `json:"speaker"`
+	// Verified is true if the quote was found in the transcripts, with
+	// the similarity Score.
+	Verified bool    `json:"verified,omitempty"`
+	Score    float64 `json:"score,omitempty"`
+}
+
+// parseQuotesMode checks the value of -quotes.
+func parseQuotesMode(s string) (string, error) {
+	switch s {
+	case "", quotesFlag, quotesDrop:
+		return s, nil
+	}
+	return "", fmt.Errorf("unknown mode %q (expected %s or %s)", s, quotesFlag, quotesDrop)
+}
+
+// extractQuotes asks the model for the quotes of the sources supporting the
+// synthesis and writes them to w. When the transcripts exceed tokenLimit
+// tokens, the quotes are extracted from one transcript at a time.
+func extractQuotes(w io.Writer, gen generation, systemInstruction, synthesis string, sources []source, tokenLimit int32) ([]quote, error) {
+	ctx := context.Background()
+
+	client, err := genai.NewClient(ctx, gen.projectID, gen.location)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create client: %w", err)
+	}
+	defer client.Close()
+
+	j := jsonGenerator{client: client, gen: gen, systemInstruction: systemInstruction, schema: quotesSchema, phase: phaseQuotes}
+	groups, tokens := j.groups(ctx, sources, tokenLimit, genai.Text(quotesPrompt), genai.Text(synthesis))
+
+	if _, err := io.WriteString(w, "\n\nKey quotes:\n"); err != nil {
+		return nil, fmt.Errorf("failed to write quotes: %w", err)
+	}
+	var quotes []quote
+	for _, group := range groups {
+		var selected []quote
+		estimate := tokens * int32(len(group)) / int32(len(sources))
+		if err := j.generate(ctx, estimate, &selected, genai.Text(quotesPrompt), genai.Text("Synthesis:\n"+synthesis), genai.Text(formatCorpus(group))); err != nil {
+			return quotes, err
+		}
+		for _, q := range selected {
+			fmt.Fprintf(w, "- %q (%s, %s)\n", q.Text, orDash(q.Speaker), q.File)
+		}
+		quotes = append(quotes, selected...)
+	}
+	return quotes, flush(w)
+}
+
+// verifyQuotes looks for every quote in the transcripts: first in the file
+// it is attributed to, then in the others, in which case the file is
+// corrected. With mode drop, the quotes not found are removed.
+func verifyQuotes(quotes []quote, sources []source, mode string) []quote {
+	normalized := make([][]string, len(sources))
+	for i, src := range sources {
+		normalized[i] = normalizeWords(src.Transcript)
+	}
+
+	var verified []quote
+	for _, q := range quotes {
+		words := normalizeWords(q.Text)
+		best := -1
+		for i, src := range sources {
+			score := matchWords(words, normalized[i])
+			if src.is(q.File) && score >= quoteMatchThreshold {
+				best, q.Score = i, score
+				break
+			}
+			if score > q.Score {
+				best, q.Score = i, score
+			}
+		}
+		q.Verified = best >= 0 && q.Score >= quoteMatchThreshold
+		if q.Verified {
+			q.File = sources[best].label()
+		} else {
+			if src, ok := findSource(sources, q.File); ok {
+				q.File = src.label()
+			}
+			logger.Warn("quote not found in the transcripts", "quote", q.Text, "file", q.File, "similarity", fmt.Sprintf("%.2f", q.Score))
+			if mode == quotesDrop {
+				continue
+			}
+		}
+		verified = append(verified, q)
+	}
+	return verified
+}
+
+// normalizeWords splits s into lower case words, without punctuation, so
+// that quotes match whatever their typography.
+func normalizeWords(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// matchWords returns the similarity, between 0 and 1, of the quote to the
+// closest passage of the text: one minus the word edit distance between
+// them over the length of the quote. The distance is the one of the
+// approximate substring matching, where the passage may start and end
+// anywhere in the text.
+func matchWords(quote, text []string) float64 {
+	n := len(quote)
+	if n == 0 {
+		return 0
+	}
+	// col[i] is the distance between quote[:i] and the best passage ending
+	// at the current word of the text.
+	col := make([]int, n+1)
+	for i := range col {
+		col[i] = i
+	}
+	best := n
+	for _, word := range text {
+		diag := col[0]
+		for i := 1; i <= n; i++ {
+			cost := 1
+			if quote[i-1] == word {
+				cost = 0
+			}
+			diag, col[i] = col[i], min(col[i]+1, col[i-1]+1, diag+cost)
+		}
+		best = min(best, col[n])
+	}
+	return 1 - float64(best)/float64(n)
+}
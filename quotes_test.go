@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+)
+
+// TestMatchWords tests the fuzzy matching of a quote against a transcript
+func TestMatchWords(t *testing.T) {
+	text := normalizeWords("Speaker A: Well, honestly, the checkout is way too slow on mobile. I gave up twice.")
+	tests := []struct {
+		quote string
+		min   float64
+		max   float64
+	}{
+		{"the checkout is way too slow on mobile", 1, 1},
+		{"“The checkout is WAY too slow on mobile…”", 1, 1},
+		{"honestly the checkout is too slow on mobile", 0.85, 0.9},
+		{"the payment page keeps crashing on my phone", 0, 0.5},
+		{"", 0, 0},
+	}
+	for _, tt := range tests {
+		if score := matchWords(normalizeWords(tt.quote), text); score < tt.min || score > tt.max {
+			t.Errorf("matchWords(%q) = %.2f, expected between %.2f and %.2f", tt.quote, score, tt.min, tt.max)
+		}
+	}
+}
+
+// TestVerifyQuotes tests that the quotes not found are flagged or dropped, and that misattributed files are corrected
+func TestVerifyQuotes(t *testing.T) {
+	sources := []source{
+		{Path: "/tmp/interview1.m4a", Transcript: "Speaker A: the checkout is way too slow on mobile"},
+		{Path: "/tmp/interview2.m4a", Transcript: "Speaker B: I always pay with my card"},
+	}
+	quotes := []quote{
+		{Text: "the checkout is way too slow on mobile", File: "interview1.m4a", Speaker: "Speaker A"},
+		{Text: "I always pay with my card", File: "interview1.m4a", Speaker: "Speaker B"},
+		{Text: "I would pay more for a faster checkout", File: "interview2.m4a", Speaker: "Speaker B"},
+	}
+
+	flagged := verifyQuotes(quotes, sources, quotesFlag)
+	if len(flagged) != 3 {
+		t.Fatalf("Expected every quote to be kept, got %+v", flagged)
+	}
+	if !flagged[0].Verified || !flagged[1].Verified || flagged[2].Verified {
+		t.Errorf("Unexpected verification: %+v", flagged)
+	}
+	if flagged[1].File != "interview2.m4a" {
+		t.Errorf("Expected the file to be corrected, got %q", flagged[1].File)
+	}
+
+	if dropped := verifyQuotes(quotes, sources, quotesDrop); len(dropped) != 2 {
+		t.Errorf("Expected the quote not found to be dropped, got %+v", dropped)
+	}
+	if _, err := parseQuotesMode("keep"); err == nil {
+		t.Error("Expected an error for an unknown mode")
+	}
+}
@@ -0,0 +1,507 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"cloud.google.com/go/vertexai/genai"
+	"gopkg.in/yaml.v3"
+)
+
+// defaultReportTemplate renders the report as markdown with a YAML front
+// matter so that it can be dropped in a Hugo or Obsidian knowledge base.
+// The front matter is skipped when the report is appended to an existing one;
+// the front matter of the existing report is updated by mergeFrontMatter.
+const defaultReportTemplate = `{{- if not .Appended -}}
+---
+title: {{ yaml .Title }}
+date: {{ .Date.Format "2006-01-02T15:04:05Z07:00" }}
+model: {{ yaml .Model }}
+prompt_version: {{ yaml .PromptVersion }}
+sources:
+{{- range .Sources }}
+  - file: {{ yaml .Path }}
+{{- with .Model }}
+    model: {{ yaml . }}
+{{- end }}
+{{- if .Duration }}
+    duration: {{ yaml .Duration.String }}
+{{- end }}
+{{- end }}
+{{- with .ContextFiles }}
+context_files:
+{{- range . }}
+  - {{ yaml . }}
+{{- end }}
+{{- end }}
+tokens:
+  prompt: {{ .Usage.Prompt }}
+  candidates: {{ .Usage.Candidates }}
+  total: {{ .Usage.Total }}
+cost_usd: {{ printf "%.4f" .Cost }}
+{{- with .Stopped }}
+stopped: {{ yaml . }}
+{{- end }}
+---
+
+{{ end -}}
+# {{ .Title }}
+
+## Transcripts
+{{ range .Sources }}
+### {{ or .Label (base .Path) }}
+
+{{ .Transcript }}
+{{ end }}
+{{- with .Corrections }}
+## Glossary corrections
+
+| File | Transcribed | Corrected | Count |
+|------|-------------|-----------|-------|
+{{- range . }}
+| {{ cell (base .File) }} | {{ cell .From }} | {{ cell .To }} | {{ .Count }} |
+{{- end }}
+{{ end }}
+{{- with .Incidents }}
+## Incidents
+
+| File | Reason | Outcome |
+|------|--------|---------|
+{{- range . }}
+| {{ cell (base .File) }} | {{ cell .Reason }} | {{ cell .Outcome }} |
+{{- end }}
+{{ end }}
+{{- if or .Synthesis .Stopped .Failed }}
+## Synthesis
+
+{{ with .Stopped }}> **Run stopped before completion:** {{ . }}
+
+{{ end }}{{ range .Failed }}> **{{ .Pass }} not included:** {{ .Error }}
+
+{{ end }}{{ .Synthesis }}
+{{- end }}
+{{- with .Quotes }}
+
+## Key quotes
+{{ range . }}
+> {{ .Text }}
+>
+> — {{ with .Speaker }}{{ . }}, {{ end }}{{ .File }}{{ if not .Verified }} *(not found in the transcripts)*{{ end }}
+{{ end }}
+{{- end }}
+{{- with .Themes }}
+
+## Themes across interviews
+
+| Theme | Interviews | Sources | Representative quote |
+|-------|------------|---------|----------------------|
+{{- range . }}
+| **{{ cell .Title }}** | {{ len .Sources }}/{{ len $.Sources }} | {{ range $i, $s := .Sources }}{{ if $i }}; {{ end }}{{ cell .File }}{{ with .Speakers }} ({{ range $k, $speaker := . }}{{ if $k }}, {{ end }}{{ cell $speaker }}{{ end }}){{ end }}{{ end }} | {{ with .Representative }}"{{ cell .Quote }}" ({{ cell .File }}{{ if not .Verified }}, not found in the transcript{{ end }}){{ end }} |
+{{- end }}
+{{- range . }}
+
+### {{ .Title }}
+
+{{ .Summary }}
+{{- range .Sources }}{{ if .Quote }}
+
+> {{ .Quote }}
+>
+> — {{ range $k, $speaker := .Speakers }}{{ if $k }}, {{ end }}{{ $speaker }}{{ end }}{{ if .Speakers }}, {{ end }}{{ .File }}{{ if not .Verified }} *(not found in the transcript)*{{ end }}
+{{- end }}{{ end }}
+{{- end }}
+{{- end }}
+{{- with .Personas }}
+{{- with .Personas }}
+
+## Personas
+{{- range . }}
+
+### {{ .Name }}
+
+{{ .Description }}
+{{- with .Interviews }}
+
+Drawn from: {{ range $i, $file := . }}{{ if $i }}, {{ end }}{{ $file }}{{ end }}
+{{- end }}
+{{- with .Goals }}
+
+**Goals**
+{{ template "attributed" . }}
+{{- end }}
+{{- with .Frustrations }}
+
+**Frustrations**
+{{ template "attributed" . }}
+{{- end }}
+{{- with .Behaviors }}
+
+**Behaviors**
+{{ template "attributed" . }}
+{{- end }}
+{{- with .Quotes }}
+
+**Quotes**
+{{ range . }}
+- {{ template "source" . }}
+{{- end }}
+{{- end }}
+{{- end }}
+{{- end }}
+{{- with .Journey }}
+
+## Journey map
+
+| Stage | Pain points | Interviews |
+|-------|-------------|------------|
+{{- range . }}
+| {{ cell .Stage }} | {{ len .PainPoints }} | {{ range $k, $file := .Interviews }}{{ if $k }}, {{ end }}{{ cell $file }}{{ end }} |
+{{- end }}
+{{- range . }}
+
+### {{ .Stage }}
+
+{{ .Description }}
+{{- range .Sources }}
+
+> {{ template "source" . }}
+{{- end }}
+{{- with .PainPoints }}
+
+**Pain points**
+{{ template "attributed" . }}
+{{- end }}
+{{- end }}
+{{- end }}
+{{- end }}
+{{- with .Minutes }}
+
+## Minutes
+{{- with .Decisions }}
+
+### Decisions
+{{ range . }}
+- {{ .Description }}{{ template "utterance" . }}
+{{- end }}
+{{- end }}
+{{- with .ActionItems }}
+
+### Action items
+{{ range . }}
+- [ ] {{ .Description }}{{ with .Owner }} (owner: {{ . }}){{ end }}{{ with .Due }}, due {{ . }}{{ end }}{{ template "utterance" . }}
+{{- end }}
+{{- end }}
+{{- with .OpenQuestions }}
+
+### Open questions
+{{ range . }}
+- {{ .Description }}{{ template "utterance" . }}
+{{- end }}
+{{- end }}
+{{- end }}
+{{- with .Coverage }}
+
+## Discussion guide coverage
+
+| # | Question |{{ range .Files }} {{ cell . }} |{{ end }}
+|---|----------|{{ range .Files }}---|{{ end }}
+{{- range .Questions }}
+| {{ .Number }} | {{ cell .Text }} |{{ range .Answers }} {{ if eq .Coverage "gap" }}**gap**{{ else }}{{ .Coverage }}{{ end }} |{{ end }}
+{{- end }}
+{{- with .Gapped }}
+
+Gaps to probe in the next round:
+{{ range . }}
+- {{ .Number }}. {{ .Text }}: not discussed in {{ .Gaps }} of {{ len .Answers }} interviews
+{{- end }}
+{{- end }}
+{{- range .Questions }}
+
+### {{ .Number }}. {{ .Text }}
+{{ range $a := .Answers }}
+- **{{ .File }}** ({{ .Coverage }}){{ with .Answer }}: {{ . }}{{ end }}
+{{- with .Quote }}
+  > {{ . }}{{ if or $a.Speaker (not $a.Verified) }} ({{ with $a.Speaker }}{{ . }}{{ end }}{{ if not $a.Verified }}{{ if $a.Speaker }}, {{ end }}not found in the transcript{{ end }}){{ end }}
+{{- end }}
+{{- end }}
+{{- end }}
+{{- end }}
+{{- with .Grounding }}
+
+## Appendix: grounding of the synthesis
+{{ range . }}
+- **{{ .Status }}**: {{ .Text }}
+{{- range .Passages }}
+  - {{ with .Speaker }}{{ . }}, {{ end }}{{ .File }}: "{{ .Excerpt }}"
+{{- end }}
+{{- end }}
+{{- end }}
+{{- with .Ledger }}
+
+## Usage
+
+| Phase | File | Chunk | Model | Prompt tokens | Candidates tokens | Total tokens | Cost (USD) |
+|-------|------|-------|-------|---------------|-------------------|--------------|------------|
+{{- range . }}
+| {{ .Phase }} | {{ with .File }}{{ cell (base .) }}{{ end }} | {{ .Chunk }} | {{ cell .Model }} | {{ .Usage.Prompt }} | {{ .Usage.Candidates }} | {{ .Usage.Total }} | {{ printf "%.4f" .Cost }} |
+{{- end }}
+| **Total** | | | | {{ $.Usage.Prompt }} | {{ $.Usage.Candidates }} | {{ $.Usage.Total }} | {{ printf "%.4f" $.Cost }} |
+{{- end }}
+
+{{ define "attributed" }}{{ range . }}
+- {{ .Text }}
+{{- range .Sources }}
+  > {{ template "source" . }}
+{{- end }}
+{{- end }}{{ end }}
+{{- define "source" }}"{{ .Text }}" ({{ with .Speaker }}{{ . }}, {{ end }}{{ .File }}{{ if not .Verified }}, not found in the transcripts{{ end }}){{ end }}
+{{- define "utterance" }}{{ with .Utterance }}
+  > {{ . }} ({{ with $.Speaker }}{{ . }}, {{ end }}{{ $.File }}{{ if not $.Verified }}, not found in the transcripts{{ end }})
+{{- end }}{{ end }}`
+
+// failedPass is a pass run after the synthesis that failed, such as the
+// extraction of the key quotes.
+type failedPass struct {
+	Pass  string `json:"pass"`
+	Error string `json:"error"`
+}
+
+// fail records that the pass failed with err, so that the report is saved
+// without its section rather than lost.
+func (r *report) fail(pass string, err error) {
+	logger.Error("pass failed, saving the report without it", "pass", pass, "error", err)
+	r.Failed = append(r.Failed, failedPass{Pass: pass, Error: err.Error()})
+}
+
+// tokenUsage is the token count reported by the model for one or several calls.
+type tokenUsage struct {
+	Prompt     int32
+	Candidates int32
+	Total      int32
+}
+
+// usageFrom converts the usage metadata of a response; m may be nil.
+func usageFrom(m *genai.UsageMetadata) tokenUsage {
+	if m == nil {
+		return tokenUsage{}
+	}
+	return tokenUsage{
+		Prompt:     m.PromptTokenCount,
+		Candidates: m.CandidatesTokenCount,
+		Total:      m.TotalTokenCount,
+	}
+}
+
+func (u *tokenUsage) add(o tokenUsage) {
+	u.Prompt += o.Prompt
+	u.Candidates += o.Candidates
+	u.Total += o.Total
+}
+
+// source is a transcribed audio file.
+type source struct {
+	Path       string
+	Duration   time.Duration
+	Transcript string
+	Usage      tokenUsage
+	// Model is the model that produced the transcript.
+	Model string
+	// Label names the source in the prompts and in the quotes, see
+	// labelSources.
+	Label string
+}
+
+// report holds everything that is exposed to the report template.
+type report struct {
+	Title string
+	Date  time.Time
+	// Model is the model that produced the synthesis; the model of every
+	// transcript is in its source.
+	Model         string
+	PromptVersion string
+	Sources       []source
+	// ContextFiles are the project documents used to frame the synthesis.
+	ContextFiles []string
+	// Corrections are the substitutions made by the glossary.
+	Corrections []correction
+	// Incidents are the files blocked by the model.
+	Incidents []incident
+	Synthesis string
+	// Structured is the structured synthesis, with -structured; Synthesis
+	// is then its markdown rendering.
+	Structured *structuredSummary
+	// Quotes are the key quotes supporting the synthesis, with the result
+	// of their verification.
+	Quotes []quote
+	// Themes are the themes across the interviews and the interviews
+	// supporting them, with -themes.
+	Themes []themeCluster
+	// Personas are the draft personas and the journey map, with -personas.
+	Personas *personaSet
+	// Minutes are the decisions, action items and open questions, with
+	// -minutes.
+	Minutes *minutes
+	// Coverage tells which questions of the guide every interview covered,
+	// with -guide.
+	Coverage *coverageMatrix
+	// Grounding is the check of the items of the synthesis against the
+	// transcripts.
+	Grounding []claim
+	// Usage is the total usage of the run, transcription and synthesis
+	// included, and Cost its estimated cost in USD.
+	Usage tokenUsage
+	Cost  float64
+	// Ledger details the usage of every call.
+	Ledger []ledgerEntry
+	// Stopped is the reason why the run stopped before completion, empty if
+	// it completed.
+	Stopped string
+	// Failed are the passes run after the synthesis that failed; the report
+	// is saved without their section.
+	Failed []failedPass
+	// Appended is true when the report is added to an existing file.
+	Appended bool
+}
+
+var reportFuncs = template.FuncMap{
+	"yaml": yamlString,
+	"base": filepath.Base,
+	"cell": tableCell,
+}
+
+// tableCell makes s fit in a cell of a markdown table: its pipes are escaped
+// and its lines joined, since either would break the table.
+func tableCell(s string) string {
+	return strings.ReplaceAll(strings.Join(strings.Fields(s), " "), "|", `\|`)
+}
+
+// yamlString quotes s so that it can safely be used as a YAML scalar.
+func yamlString(s string) string {
+	return strconv.Quote(s)
+}
+
+// loadReportTemplate parses the template file at path, or the default
+// template if path is empty.
+func loadReportTemplate(path string) (*template.Template, error) {
+	if path == "" {
+		return template.New("report").Funcs(reportFuncs).Parse(defaultReportTemplate)
+	}
+	tmpl, err := template.New(filepath.Base(path)).Funcs(reportFuncs).ParseFiles(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// reportTitle derives a title from the output file, or from the first
+// audio file when writing to stdout.
+func reportTitle(outputFile string, filePaths []string) string {
+	name := outputFile
+	if name == "" && len(filePaths) > 0 {
+		name = filePaths[0]
+	}
+	name = filepath.Base(name)
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
+
+func (r report) render(w io.Writer, tmpl *template.Template) error {
+	if err := tmpl.Execute(w, r); err != nil {
+		return fmt.Errorf("unable to render report: %w", err)
+	}
+	return nil
+}
+
+// mergeFrontMatter adds the sources, the usage and the cost of the report to
+// the YAML front matter at the start of content, the report it is appended
+// to, so that the metadata covers every transcript. The fields missing from
+// the front matter, as with a custom template, are left out; content is
+// returned as is if it has no front matter.
+func (r report) mergeFrontMatter(content []byte) []byte {
+	rest, ok := bytes.CutPrefix(content, []byte("---\n"))
+	if !ok {
+		return content
+	}
+	end := bytes.Index(rest, []byte("\n---\n"))
+	if end < 0 {
+		return content
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(rest[:end+1], &doc); err != nil || len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		logger.Warn("unable to update the front matter of the report", "error", err)
+		return content
+	}
+	fields := doc.Content[0]
+
+	if sources := mappingValue(fields, "sources"); sources != nil && (sources.Kind == yaml.SequenceNode || sources.Tag == "!!null") {
+		sources.Kind, sources.Tag, sources.Value = yaml.SequenceNode, "!!seq", ""
+		for _, src := range r.Sources {
+			entry := &yaml.Node{Kind: yaml.MappingNode}
+			setField(entry, "file", src.Path)
+			if src.Model != "" {
+				setField(entry, "model", src.Model)
+			}
+			if src.Duration > 0 {
+				setField(entry, "duration", src.Duration.String())
+			}
+			sources.Content = append(sources.Content, entry)
+		}
+	}
+	if tokens := mappingValue(fields, "tokens"); tokens != nil {
+		for key, n := range map[string]int32{"prompt": r.Usage.Prompt, "candidates": r.Usage.Candidates, "total": r.Usage.Total} {
+			var total int64
+			if v := mappingValue(tokens, key); v != nil && v.Decode(&total) == nil {
+				v.Value = strconv.FormatInt(total+int64(n), 10)
+			}
+		}
+	}
+	var cost float64
+	if v := mappingValue(fields, "cost_usd"); v != nil && v.Decode(&cost) == nil {
+		v.Value = fmt.Sprintf("%.4f", cost+r.Cost)
+	}
+	if r.Stopped != "" {
+		if v := mappingValue(fields, "stopped"); v != nil {
+			v.Value = r.Stopped
+		} else {
+			setField(fields, "stopped", r.Stopped)
+		}
+	}
+
+	var b bytes.Buffer
+	b.WriteString("---\n")
+	enc := yaml.NewEncoder(&b)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		logger.Warn("unable to update the front matter of the report", "error", err)
+		return content
+	}
+	enc.Close()
+	b.Write(rest[end+1:])
+	return b.Bytes()
+}
+
+// mappingValue returns the value of key in the YAML mapping, or nil.
+func mappingValue(mapping *yaml.Node, key string) *yaml.Node {
+	if mapping.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// setField adds key to the YAML mapping with a quoted string value, as the
+// yaml function of the template does.
+func setField(mapping *yaml.Node, key, value string) {
+	mapping.Content = append(mapping.Content,
+		&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key},
+		&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value, Style: yaml.DoubleQuotedStyle})
+}
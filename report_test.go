@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testReport() report {
+	return report{
+		Title:         "interview",
+		Date:          time.Date(2025, 3, 14, 10, 0, 0, 0, time.UTC),
+		Model:         "gemini-2.0-flash",
+		PromptVersion: "abcd1234",
+		Sources: []source{
+			{Path: "/tmp/chunk_000.m4a", Duration: 25 * time.Minute, Transcript: "Speaker A: hello"},
+			{Path: "/tmp/chunk_001.m4a", Transcript: "Speaker B: \"quoted\" answer"},
+		},
+		Synthesis: "## Key Takeaways\n- one",
+		Usage:     tokenUsage{Prompt: 100, Candidates: 20, Total: 120},
+		Cost:      0.00123,
+	}
+}
+
+// TestDefaultReportTemplate tests the front matter and body of the built-in template
+func TestDefaultReportTemplate(t *testing.T) {
+	tmpl, err := loadReportTemplate("")
+	if err != nil {
+		t.Fatalf("failed to load default template: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := testReport().render(&buf, tmpl); err != nil {
+		t.Fatalf("render failed: %v", err)
+	}
+	content := buf.String()
+	t.Logf("Output:\n%s", content)
+
+	if !strings.HasPrefix(content, "---\ntitle: \"interview\"\n") {
+		t.Errorf("Expected report to start with the front matter, got:\n%s", content)
+	}
+	for _, expected := range []string{
+		"date: 2025-03-14T10:00:00Z\n",
+		"prompt_version: \"abcd1234\"\n",
+		"  - file: \"/tmp/chunk_000.m4a\"\n    duration: \"25m0s\"\n  - file: \"/tmp/chunk_001.m4a\"\ntokens:\n",
+		"  total: 120\ncost_usd: 0.0012\n---\n\n# interview\n",
+		"### chunk_001.m4a\n\nSpeaker B: \"quoted\" answer\n",
+		"## Synthesis\n\n## Key Takeaways\n- one\n",
+	} {
+		if !strings.Contains(content, expected) {
+			t.Errorf("Expected report to contain %q", expected)
+		}
+	}
+}
+
+// TestDefaultReportTemplateContextFiles tests that the context files are listed in the front matter
+func TestDefaultReportTemplateContextFiles(t *testing.T) {
+	tmpl, err := loadReportTemplate("")
+	if err != nil {
+		t.Fatalf("failed to load default template: %v", err)
+	}
+
+	rep := testReport()
+	rep.ContextFiles = []string{"brief.md", "glossary.md"}
+	var buf bytes.Buffer
+	if err := rep.render(&buf, tmpl); err != nil {
+		t.Fatalf("render failed: %v", err)
+	}
+	expected := "context_files:\n  - \"brief.md\"\n  - \"glossary.md\"\ntokens:\n"
+	if !strings.Contains(buf.String(), expected) {
+		t.Errorf("Expected report to contain %q, got:\n%s", expected, buf.String())
+	}
+}
+
+// TestDefaultReportTemplateCorrections tests the glossary corrections table
+func TestDefaultReportTemplateCorrections(t *testing.T) {
+	tmpl, err := loadReportTemplate("")
+	if err != nil {
+		t.Fatalf("failed to load default template: %v", err)
+	}
+
+	rep := testReport()
+	rep.Corrections = []correction{{File: "/tmp/chunk_000.m4a", From: "Shopwyse", To: "Shopwise", Count: 2}}
+	var buf bytes.Buffer
+	if err := rep.render(&buf, tmpl); err != nil {
+		t.Fatalf("render failed: %v", err)
+	}
+	expected := "\n## Glossary corrections\n\n| File | Transcribed | Corrected | Count |\n|------|-------------|-----------|-------|\n| chunk_000.m4a | Shopwyse | Shopwise | 2 |\n\n## Synthesis\n"
+	if !strings.Contains(buf.String(), expected) {
+		t.Errorf("Expected report to contain %q, got:\n%s", expected, buf.String())
+	}
+	if strings.Contains(testRender(t, testReport()), "Glossary corrections") {
+		t.Error("Expected no corrections section without corrections")
+	}
+}
+
+func testRender(t *testing.T, rep report) string {
+	t.Helper()
+	tmpl, err := loadReportTemplate("")
+	if err != nil {
+		t.Fatalf("failed to load default template: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := rep.render(&buf, tmpl); err != nil {
+		t.Fatalf("render failed: %v", err)
+	}
+	return buf.String()
+}
+
+// TestDefaultReportTemplateLedger tests the usage table
+func TestDefaultReportTemplateLedger(t *testing.T) {
+	rep := testReport()
+	rep.Ledger = []ledgerEntry{
+		{Phase: phaseTranscription, File: "/tmp/chunk_000.m4a", Model: "gemini-2.0-flash", Usage: tokenUsage{Prompt: 80, Candidates: 10, Total: 90}, Cost: 0.001},
+		{Phase: phaseSynthesis, Model: "gemini-2.0-flash", Usage: tokenUsage{Prompt: 20, Candidates: 10, Total: 30}, Cost: 0.00023},
+	}
+	content := testRender(t, rep)
+	for _, expected := range []string{
+		"\n## Usage\n",
+		"| transcription | chunk_000.m4a |  | gemini-2.0-flash | 80 | 10 | 90 | 0.0010 |\n",
+		"| synthesis |  |  | gemini-2.0-flash | 20 | 10 | 30 | 0.0002 |\n",
+		"| **Total** | | | | 100 | 20 | 120 | 0.0012 |\n",
+	} {
+		if !strings.Contains(content, expected) {
+			t.Errorf("Expected report to contain %q, got:\n%s", expected, content)
+		}
+	}
+}
+
+// TestDefaultReportTemplateStopped tests the note of a run stopped by its budget
+func TestDefaultReportTemplateStopped(t *testing.T) {
+	rep := testReport()
+	rep.Synthesis = ""
+	rep.Stopped = "budget reached: 1.2000 USD, limit 1.0000 USD (-max-cost)"
+	content := testRender(t, rep)
+	for _, expected := range []string{
+		"\nstopped: \"budget reached: 1.2000 USD, limit 1.0000 USD (-max-cost)\"\n---\n",
+		"## Synthesis\n\n> **Run stopped before completion:** budget reached",
+	} {
+		if !strings.Contains(content, expected) {
+			t.Errorf("Expected report to contain %q, got:\n%s", expected, content)
+		}
+	}
+}
+
+// TestDefaultReportTemplateFailed tests the note of a pass that failed after the synthesis
+func TestDefaultReportTemplateFailed(t *testing.T) {
+	rep := testReport()
+	rep.fail("Key quotes", errors.New("invalid response: unexpected end of JSON input"))
+	expected := "## Synthesis\n\n> **Key quotes not included:** invalid response: unexpected end of JSON input\n\n## Key Takeaways\n"
+	if content := testRender(t, rep); !strings.Contains(content, expected) {
+		t.Errorf("Expected report to contain %q, got:\n%s", expected, content)
+	}
+}
+
+// TestDefaultReportTemplateAppended tests that an appended report has no front matter
+func TestDefaultReportTemplateAppended(t *testing.T) {
+	tmpl, err := loadReportTemplate("")
+	if err != nil {
+		t.Fatalf("failed to load default template: %v", err)
+	}
+
+	rep := testReport()
+	rep.Appended = true
+	var buf bytes.Buffer
+	if err := rep.render(&buf, tmpl); err != nil {
+		t.Fatalf("render failed: %v", err)
+	}
+	if !strings.HasPrefix(buf.String(), "# interview\n") {
+		t.Errorf("Expected appended report to start with the title, got:\n%s", buf.String())
+	}
+}
+
+// TestMergeFrontMatter tests that the front matter of an appended report covers every transcript
+func TestMergeFrontMatter(t *testing.T) {
+	tmpl, err := loadReportTemplate("")
+	if err != nil {
+		t.Fatalf("failed to load default template: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := testReport().render(&buf, tmpl); err != nil {
+		t.Fatalf("render failed: %v", err)
+	}
+	existing := buf.String()
+	body := existing[strings.Index(existing, "\n---\n")+1:]
+
+	appended := report{
+		Sources: []source{{Path: "/tmp/chunk_002.m4a", Model: "gemini-2.5-pro", Duration: 10 * time.Minute}},
+		Usage:   tokenUsage{Prompt: 50, Candidates: 5, Total: 55},
+		Cost:    0.001,
+		Stopped: "budget exceeded",
+	}
+	merged := string(appended.mergeFrontMatter([]byte(existing)))
+	expected := `---
+title: "interview"
+date: 2025-03-14T10:00:00Z
+model: "gemini-2.0-flash"
+prompt_version: "abcd1234"
+sources:
+  - file: "/tmp/chunk_000.m4a"
+    duration: "25m0s"
+  - file: "/tmp/chunk_001.m4a"
+  - file: "/tmp/chunk_002.m4a"
+    model: "gemini-2.5-pro"
+    duration: "10m0s"
+tokens:
+  prompt: 150
+  candidates: 25
+  total: 175
+cost_usd: 0.0022
+stopped: "budget exceeded"
+`
+	if !strings.HasPrefix(merged, expected) {
+		t.Errorf("Expected the front matter:\n%s\ngot:\n%s", expected, merged)
+	}
+	if !strings.HasSuffix(merged, body) {
+		t.Errorf("Expected the body to be kept, got:\n%s", merged)
+	}
+
+	if got := appended.mergeFrontMatter([]byte("# notes\n")); string(got) != "# notes\n" {
+		t.Errorf("Expected a report without front matter to be kept, got %q", got)
+	}
+}
+
+// TestCustomReportTemplate tests loading a template override from a file
+func TestCustomReportTemplate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "custom.tmpl")
+	custom := `{{ range .Sources }}{{ base .Path }}={{ .Transcript }};{{ end }}{{ yaml .Synthesis }}`
+	if err := os.WriteFile(path, []byte(custom), 0o644); err != nil {
+		t.Fatalf("failed to write template: %v", err)
+	}
+
+	tmpl, err := loadReportTemplate(path)
+	if err != nil {
+		t.Fatalf("failed to load template: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := testReport().render(&buf, tmpl); err != nil {
+		t.Fatalf("render failed: %v", err)
+	}
+	expected := `chunk_000.m4a=Speaker A: hello;chunk_001.m4a=Speaker B: "quoted" answer;"## Key Takeaways\n- one"`
+	if buf.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, buf.String())
+	}
+}
+
+// TestReportTitle tests how the title is derived
+func TestReportTitle(t *testing.T) {
+	if got := reportTitle("notes/interview-42.md", []string{"a.m4a"}); got != "interview-42" {
+		t.Errorf("Expected title from output file, got %q", got)
+	}
+	if got := reportTitle("", []string{"/tmp/meeting.m4a"}); got != "meeting" {
+		t.Errorf("Expected title from first audio file, got %q", got)
+	}
+}
+
+// TestDefaultReportTemplateQuotes tests that the quotes not found in the transcripts are flagged
+func TestDefaultReportTemplateQuotes(t *testing.T) {
+	tmpl, err := loadReportTemplate("")
+	if err != nil {
+		t.Fatalf("failed to load default template: %v", err)
+	}
+	rep := testReport()
+	rep.Quotes = []quote{
+		{Text: "hello", File: "chunk_000.m4a", Speaker: "Speaker A", Verified: true},
+		{Text: "goodbye", File: "chunk_001.m4a"},
+	}
+	var buf bytes.Buffer
+	if err := rep.render(&buf, tmpl); err != nil {
+		t.Fatalf("render failed: %v", err)
+	}
+	expected := "## Key Takeaways\n- one\n\n## Key quotes\n\n> hello\n>\n> — Speaker A, chunk_000.m4a\n\n> goodbye\n>\n> — chunk_001.m4a *(not found in the transcripts)*\n"
+	if !strings.Contains(buf.String(), expected) {
+		t.Errorf("Expected report to contain %q, got:\n%s", expected, buf.String())
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/vertexai/genai"
+)
+
+// blockedError is returned when the model refuses to process a request, either
+// because of the prompt (the audio) or because of the response it generated.
+type blockedError struct {
+	// Prompt is true if the request itself was blocked.
+	Prompt  bool
+	Reason  string
+	Message string
+}
+
+func (e *blockedError) Error() string {
+	var b strings.Builder
+	if e.Prompt {
+		fmt.Fprintf(&b, "request blocked by the model (%s)", e.Reason)
+	} else {
+		fmt.Fprintf(&b, "response blocked by the model (%s)", e.Reason)
+	}
+	if e.Message != "" {
+		fmt.Fprintf(&b, ": %s", e.Message)
+	}
+	switch {
+	case strings.Contains(e.Reason, "Recitation"):
+		b.WriteString("; the output matched existing content: use -on-blocked=model,split to retry with another model or on smaller chunks")
+	default:
+		b.WriteString("; lower the filters with -safety-threshold, or use -on-blocked=model,split to retry with another model or on smaller chunks")
+	}
+	return b.String()
+}
+
+// isBlocked reports whether the finish reason means the response was blocked.
+func isBlocked(reason genai.FinishReason) bool {
+	switch reason {
+	case genai.FinishReasonSafety,
+		genai.FinishReasonRecitation,
+		genai.FinishReasonBlocklist,
+		genai.FinishReasonProhibitedContent,
+		genai.FinishReasonSpii:
+		return true
+	}
+	return false
+}
+
+// asBlockedError converts the *genai.BlockedError returned by the SDK into a
+// *blockedError. Other errors are returned unchanged.
+func asBlockedError(err error) error {
+	var be *genai.BlockedError
+	if !errors.As(err, &be) {
+		return err
+	}
+	if be.PromptFeedback != nil {
+		return &blockedError{Prompt: true, Reason: be.PromptFeedback.BlockReason.String(), Message: be.PromptFeedback.BlockReasonMessage}
+	}
+	if be.Candidate != nil {
+		return &blockedError{Reason: be.Candidate.FinishReason.String(), Message: be.Candidate.FinishMessage}
+	}
+	return &blockedError{Reason: "unknown"}
+}
+
+var safetyThresholds = map[string]genai.HarmBlockThreshold{
+	"low_and_above":    genai.HarmBlockLowAndAbove,
+	"medium_and_above": genai.HarmBlockMediumAndAbove,
+	"only_high":        genai.HarmBlockOnlyHigh,
+	"none":             genai.HarmBlockNone,
+}
+
+// safetySettings returns the settings applying threshold to every harm
+// category. An empty threshold keeps the defaults of the API.
+func safetySettings(threshold string) ([]*genai.SafetySetting, error) {
+	if threshold == "" {
+		return nil, nil
+	}
+	t, ok := safetyThresholds[threshold]
+	if !ok {
+		return nil, fmt.Errorf("unknown safety threshold %q (expected low_and_above, medium_and_above, only_high or none)", threshold)
+	}
+	var settings []*genai.SafetySetting
+	for _, category := range []genai.HarmCategory{
+		genai.HarmCategoryHateSpeech,
+		genai.HarmCategoryDangerousContent,
+		genai.HarmCategoryHarassment,
+		genai.HarmCategorySexuallyExplicit,
+	} {
+		settings = append(settings, &genai.SafetySetting{Category: category, Threshold: t})
+	}
+	return settings, nil
+}
+
+// Strategies applied, in order, when the transcription of a file is blocked.
+const (
+	onBlockedModel = "model" // retry with the fallback model
+	onBlockedSplit = "split" // split the file in two and transcribe each half
+	onBlockedSkip  = "skip"  // leave the file out of the report
+)
+
+// blockedPlaceholder replaces the transcript of a blocked part.
+const blockedPlaceholder = "[part of the recording blocked by the model]"
+
+// parseOnBlocked parses a comma separated list of strategies; "fail" or an
+// empty string means no strategy.
+func parseOnBlocked(s string) ([]string, error) {
+	if s == "" || s == "fail" {
+		return nil, nil
+	}
+	var strategies []string
+	for _, strategy := range strings.Split(s, ",") {
+		strategy = strings.TrimSpace(strategy)
+		switch strategy {
+		case onBlockedModel, onBlockedSplit, onBlockedSkip:
+			strategies = append(strategies, strategy)
+		default:
+			return nil, fmt.Errorf("unknown strategy %q (expected fail, or a list of model, split and skip)", strategy)
+		}
+	}
+	return strategies, nil
+}
+
+// incident records a blocked file and what was done about it.
+type incident struct {
+	File    string
+	Reason  string
+	Outcome string
+}
+
+// transcribeWithFallback transcribes the audio file, moving down the model
+// chain of gen if needed, and applies the strategies when the model blocks
+// it. The returned source holds the transcript, the usage of the calls and the
+// model that produced the transcript. The returned incident is nil if the file
+// was not blocked.
+func transcribeWithFallback(w io.Writer, gen generation, fallbackModel, prompt, audioFilePath string, strategies []string) (source, *incident, error) {
+	src := source{Path: audioFilePath}
+	gen, err := gen.withFallback(func(gen generation) error {
+		var (
+			usage tokenUsage
+			err   error
+		)
+		src.Transcript, usage, err = transcribeAudio(w, gen, prompt, audioFilePath)
+		gen.record(phaseTranscription, audioFilePath, "", usage)
+		src.Usage.add(usage)
+		return err
+	})
+	src.Model = gen.model
+	var blocked *blockedError
+	if !errors.As(err, &blocked) {
+		return src, nil, err
+	}
+	src.Transcript = ""
+
+	inc := &incident{File: audioFilePath, Reason: blocked.Reason}
+	logger.Warn("transcription blocked", "file", audioFilePath, "reason", blocked.Reason, "message", blocked.Message)
+	for _, strategy := range strategies {
+		if err := gen.check(phaseTranscription, tokenUsage{}); err != nil {
+			return src, inc, err
+		}
+		switch strategy {
+		case onBlockedModel:
+			if fallbackModel == "" || fallbackModel == gen.model {
+				logger.Warn("no fallback model to retry with, set -fallback-model", "file", audioFilePath)
+				continue
+			}
+			logger.Info("retrying with the fallback model", "file", audioFilePath, "model", fallbackModel)
+			retry := gen.withModels([]string{fallbackModel})
+			transcript, u, err := transcribeAudio(w, retry, prompt, audioFilePath)
+			retry.record(phaseTranscription, audioFilePath, "", u)
+			src.Usage.add(u)
+			if err == nil {
+				inc.Outcome = "transcribed with " + fallbackModel
+				src.Transcript, src.Model = transcript, fallbackModel
+				return src, inc, nil
+			}
+			if !errors.As(err, &blocked) {
+				return src, inc, err
+			}
+		case onBlockedSplit:
+			logger.Info("retrying on smaller chunks", "file", audioFilePath)
+			transcript, u, outcome, err := transcribeHalves(w, gen, prompt, audioFilePath)
+			src.Usage.add(u)
+			if err == nil {
+				inc.Outcome = outcome
+				src.Transcript = transcript
+				return src, inc, nil
+			}
+			logger.Warn("unable to transcribe smaller chunks", "file", audioFilePath, "error", err)
+		case onBlockedSkip:
+			inc.Outcome = "skipped"
+			src.Transcript, src.Model = blockedPlaceholder, ""
+			return src, inc, nil
+		}
+	}
+	return src, inc, blocked
+}
+
+// halfDuration returns the duration of the segments splitting an audio file
+// of the given duration in two: half of it rounded up to whole seconds, since
+// ffmpeg segments on whole seconds, and at least a second.
+func halfDuration(d time.Duration) time.Duration {
+	half := ((d+time.Second)/2 + time.Second - 1).Truncate(time.Second)
+	if half < time.Second {
+		return time.Second
+	}
+	return half
+}
+
+// transcribeHalves splits the audio file in two and transcribes each half.
+// Halves that are still blocked are replaced by blockedPlaceholder; it fails
+// only if both halves are blocked.
+func transcribeHalves(w io.Writer, gen generation, prompt, audioFilePath string) (string, tokenUsage, string, error) {
+	var usage tokenUsage
+	ctx := context.Background()
+	duration, err := probeDuration(ctx, audioFilePath)
+	if err != nil {
+		return "", usage, "", err
+	}
+	dir, err := os.MkdirTemp("", "audiotranscribe-split-")
+	if err != nil {
+		return "", usage, "", fmt.Errorf("os.MkdirTemp: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	parts, err := splitAudio(ctx, audioFilePath, dir, halfDuration(duration))
+	if err != nil {
+		return "", usage, "", err
+	}
+
+	var transcripts []string
+	blockedParts := 0
+	for i, part := range parts {
+		transcript, u, err := transcribeAudio(w, gen, prompt, part)
+		gen.record(phaseTranscription, audioFilePath, fmt.Sprintf("%d/%d", i+1, len(parts)), u)
+		usage.add(u)
+		var blocked *blockedError
+		switch {
+		case errors.As(err, &blocked):
+			logger.Warn("chunk still blocked", "file", audioFilePath, "part", filepath.Base(part), "reason", blocked.Reason)
+			blockedParts++
+			transcript = blockedPlaceholder
+		case err != nil:
+			return "", usage, "", err
+		}
+		transcripts = append(transcripts, transcript)
+	}
+	if blockedParts == len(parts) {
+		return "", usage, "", errors.New("every part is blocked")
+	}
+	outcome := fmt.Sprintf("split in %d parts, %d blocked", len(parts), blockedParts)
+	return strings.Join(transcripts, "\n\n"), usage, outcome, nil
+}
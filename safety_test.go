@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/vertexai/genai"
+)
+
+// TestResponseTextBlocked tests that blocked candidates and prompts are reported as typed errors
+func TestResponseTextBlocked(t *testing.T) {
+	tests := []struct {
+		name   string
+		res    *genai.GenerateContentResponse
+		prompt bool
+		reason string
+	}{
+		{
+			name: "recitation",
+			res: &genai.GenerateContentResponse{Candidates: []*genai.Candidate{{
+				Content:      &genai.Content{Parts: []genai.Part{genai.Text("partial")}},
+				FinishReason: genai.FinishReasonRecitation,
+			}}},
+			reason: "FinishReasonRecitation",
+		},
+		{
+			name:   "safety without content",
+			res:    &genai.GenerateContentResponse{Candidates: []*genai.Candidate{{FinishReason: genai.FinishReasonSafety}}},
+			reason: "FinishReasonSafety",
+		},
+		{
+			name: "prompt",
+			res: &genai.GenerateContentResponse{PromptFeedback: &genai.PromptFeedback{
+				BlockReason:        genai.BlockedReasonProhibitedContent,
+				BlockReasonMessage: "prohibited",
+			}},
+			prompt: true,
+			reason: "BlockedReasonProhibitedContent",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, _, err := responseText(tt.res)
+			var blocked *blockedError
+			if !errors.As(err, &blocked) {
+				t.Fatalf("Expected a *blockedError, got %v", err)
+			}
+			if blocked.Prompt != tt.prompt || blocked.Reason != tt.reason {
+				t.Errorf("Expected prompt=%v reason=%s, got %+v", tt.prompt, tt.reason, blocked)
+			}
+		})
+	}
+}
+
+// TestAsBlockedError tests the conversion of the SDK error
+func TestAsBlockedError(t *testing.T) {
+	sdkErr := &genai.BlockedError{Candidate: &genai.Candidate{FinishReason: genai.FinishReasonSafety, FinishMessage: "harassment"}}
+	err := asBlockedError(fmt.Errorf("wrapped: %w", sdkErr))
+
+	var blocked *blockedError
+	if !errors.As(err, &blocked) {
+		t.Fatalf("Expected a *blockedError, got %v", err)
+	}
+	if blocked.Reason != "FinishReasonSafety" || blocked.Message != "harassment" {
+		t.Errorf("Unexpected conversion: %+v", blocked)
+	}
+	if !strings.Contains(err.Error(), "-safety-threshold") {
+		t.Errorf("Expected the error to suggest -safety-threshold, got %q", err)
+	}
+
+	other := errors.New("network down")
+	if asBlockedError(other) != other {
+		t.Error("Expected other errors to be returned unchanged")
+	}
+}
+
+// TestSafetySettings tests the parsing of the safety threshold
+func TestSafetySettings(t *testing.T) {
+	settings, err := safetySettings("")
+	if err != nil || settings != nil {
+		t.Errorf("Expected no settings for an empty threshold, got %v, %v", settings, err)
+	}
+
+	settings, err = safetySettings("only_high")
+	if err != nil {
+		t.Fatalf("safetySettings failed: %v", err)
+	}
+	if len(settings) != 4 {
+		t.Errorf("Expected a setting per harm category, got %d", len(settings))
+	}
+	for _, s := range settings {
+		if s.Threshold != genai.HarmBlockOnlyHigh {
+			t.Errorf("Expected threshold %v, got %v", genai.HarmBlockOnlyHigh, s.Threshold)
+		}
+	}
+
+	if _, err := safetySettings("relaxed"); err == nil {
+		t.Error("Expected an error for an unknown threshold")
+	}
+}
+
+// TestParseOnBlocked tests the parsing of the fallback strategies
+func TestParseOnBlocked(t *testing.T) {
+	for input, expected := range map[string][]string{
+		"":                  nil,
+		"fail":              nil,
+		"model":             {"model"},
+		"model, split,skip": {"model", "split", "skip"},
+	} {
+		got, err := parseOnBlocked(input)
+		if err != nil {
+			t.Errorf("parseOnBlocked(%q) failed: %v", input, err)
+		}
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("parseOnBlocked(%q) = %q, expected %q", input, got, expected)
+		}
+	}
+	if _, err := parseOnBlocked("model,retry"); err == nil {
+		t.Error("Expected an error for an unknown strategy")
+	}
+}
+
+// TestHalfDuration tests that a file is split in two segments of whole seconds
+func TestHalfDuration(t *testing.T) {
+	tests := []struct {
+		duration, half time.Duration
+	}{
+		{61 * time.Second, 31 * time.Second},
+		{60 * time.Second, 31 * time.Second},
+		{59 * time.Second, 30 * time.Second},
+		{1500 * time.Millisecond, 2 * time.Second},
+		{time.Second, time.Second},
+		{0, time.Second},
+	}
+	for _, tt := range tests {
+		if got := halfDuration(tt.duration); got != tt.half {
+			t.Errorf("halfDuration(%s) = %s, expected %s", tt.duration, got, tt.half)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	// maxUploadMemory is the part of an upload kept in memory, the rest is
+	// stored in temporary files.
+	maxUploadMemory = 32 << 20
+	// readHeaderTimeout and readTimeout bound the time a client takes to
+	// send its request, so that slow clients do not hold connections.
+	readHeaderTimeout = 10 * time.Second
+	readTimeout       = 10 * time.Minute
+)
+
+// server runs the pipeline on the audio files posted to /transcribe, one
+// request at a time.
+type server struct {
+	config configuration
+	opts   options
+	// maxUpload is the largest request body accepted, in bytes.
+	maxUpload int64
+
+	mu sync.Mutex
+}
+
+func serveCommand(cmd *command, args []string) error {
+	f := newCLIFlags(cmd, flagsReport|flagsPrompts|flagsTranscription|flagsSynthesis|flagsCost)
+	addr := f.fs.String("addr", "localhost:8080", "Address to listen on.")
+	maxUpload := f.fs.Int64("max-upload", 500, "Largest upload accepted, in MB.")
+	if err := f.parse(args); err != nil {
+		return err
+	}
+	config, err := f.config()
+	if err != nil {
+		return err
+	}
+	opts, err := f.options()
+	if err != nil {
+		return err
+	}
+	if *maxUpload <= 0 {
+		return errors.New("-max-upload must be positive")
+	}
+
+	s := &server{config: config, opts: opts, maxUpload: *maxUpload << 20}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /transcribe", s.transcribe)
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok\n")
+	})
+	srv := &http.Server{
+		Addr:              *addr,
+		Handler:           mux,
+		ReadHeaderTimeout: readHeaderTimeout,
+		ReadTimeout:       readTimeout,
+	}
+	logger.Info("serving", "addr", *addr, "max_upload_mb", *maxUpload)
+	return srv.ListenAndServe()
+}
+
+// transcribe runs the pipeline on the files of the "audio" field of a
+// multipart form and responds with the report. With synthesis=false, the
+// report holds the transcripts only. A request larger than maxUpload is
+// rejected.
+func (s *server) transcribe(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, s.maxUpload)
+	if err := r.ParseMultipartForm(maxUploadMemory); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, fmt.Sprintf("upload larger than %d MB", s.maxUpload>>20), http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, fmt.Sprintf("invalid form: %v", err), http.StatusBadRequest)
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+	uploads := r.MultipartForm.File["audio"]
+	if len(uploads) == 0 {
+		http.Error(w, "at least one audio file required in the audio field", http.StatusBadRequest)
+		return
+	}
+
+	dir, err := os.MkdirTemp("", "audiotranscribe-serve-")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	var filePaths []string
+	for i, upload := range uploads {
+		path := filepath.Join(dir, fmt.Sprintf("%02d-%s", i+1, filepath.Base(upload.Filename)))
+		if err := saveUpload(upload, path); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		filePaths = append(filePaths, path)
+	}
+
+	opts := s.opts
+	opts.outputFile = filepath.Join(dir, "report.md")
+	opts.skipSynthesis = r.FormValue("synthesis") == "false"
+
+	s.mu.Lock()
+	runErr := run(s.config, opts, filePaths)
+	s.mu.Unlock()
+	if runErr != nil && !errors.Is(runErr, errBudget) {
+		logger.Error("request failed", "files", len(filePaths), "error", runErr)
+		http.Error(w, runErr.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	report, err := os.ReadFile(opts.outputFile)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+	if runErr != nil {
+		// The budget was reached: the report is partial.
+		w.Header().Set("X-Run-Stopped", runErr.Error())
+	}
+	w.Write(report)
+}
+
+// saveUpload copies an uploaded file to path.
+func saveUpload(upload *multipart.FileHeader, path string) error {
+	src, err := upload.Open()
+	if err != nil {
+		return fmt.Errorf("failed to read upload: %w", err)
+	}
+	defer src.Close()
+	dst, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("os.Create: %w", err)
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return fmt.Errorf("io.Copy: %w", err)
+	}
+	return dst.Close()
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestServerUploadLimit tests that an upload larger than the limit is rejected before the run
+func TestServerUploadLimit(t *testing.T) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	part, err := mw.CreateFormFile("audio", "interview.m4a")
+	if err != nil {
+		t.Fatalf("CreateFormFile failed: %v", err)
+	}
+	part.Write(make([]byte, 2048))
+	mw.Close()
+
+	s := &server{maxUpload: 1024}
+	req := httptest.NewRequest(http.MethodPost, "/transcribe", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	rec := httptest.NewRecorder()
+	s.transcribe(rec, req)
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected status %d, got %d: %s", http.StatusRequestEntityTooLarge, rec.Code, rec.Body.String())
+	}
+}
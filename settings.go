@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"cloud.google.com/go/vertexai/genai"
+)
+
+// phaseSettings are the model and the generation settings of a phase, read
+// from GEMINI_TRANSCRIPTION_* and GEMINI_SYNTHESIS_*. Unset pointers keep the
+// defaults of the API.
+type phaseSettings struct {
+	// Model is a comma separated list of models, see configuration.models.
+	Model           string   `envconfig:"MODEL"`
+	Temperature     float32  `envconfig:"TEMPERATURE" default:"0.4"`
+	TopP            *float32 `envconfig:"TOP_P"`
+	TopK            *int32   `envconfig:"TOP_K"`
+	MaxOutputTokens *int32   `envconfig:"MAX_OUTPUT_TOKENS"`
+	// SystemInstruction frames every call of the phase. The synthesis adds
+	// the project context to it.
+	SystemInstruction string `envconfig:"SYSTEM_INSTRUCTION"`
+}
+
+// validate checks the ranges of the settings; prefix names the variables in
+// the errors.
+func (p phaseSettings) validate(prefix string) error {
+	var errs []error
+	if p.Temperature < 0 || p.Temperature > 2 {
+		errs = append(errs, fmt.Errorf("%s_TEMPERATURE must be between 0 and 2, got %v", prefix, p.Temperature))
+	}
+	if p.TopP != nil && (*p.TopP <= 0 || *p.TopP > 1) {
+		errs = append(errs, fmt.Errorf("%s_TOP_P must be in ]0, 1], got %v", prefix, *p.TopP))
+	}
+	if p.TopK != nil && *p.TopK < 1 {
+		errs = append(errs, fmt.Errorf("%s_TOP_K must be positive, got %d", prefix, *p.TopK))
+	}
+	if p.MaxOutputTokens != nil && *p.MaxOutputTokens < 1 {
+		errs = append(errs, fmt.Errorf("%s_MAX_OUTPUT_TOKENS must be positive, got %d", prefix, *p.MaxOutputTokens))
+	}
+	return errors.Join(errs...)
+}
+
+// apply sets the generation settings on the model.
+func (p phaseSettings) apply(model *genai.GenerativeModel) {
+	model.SetTemperature(p.Temperature)
+	if p.TopP != nil {
+		model.SetTopP(*p.TopP)
+	}
+	if p.TopK != nil {
+		model.SetTopK(*p.TopK)
+	}
+	if p.MaxOutputTokens != nil {
+		model.SetMaxOutputTokens(*p.MaxOutputTokens)
+	}
+	if p.SystemInstruction != "" {
+		model.SystemInstruction = genai.NewUserContent(genai.Text(p.SystemInstruction))
+	}
+}
+
+// settings returns the settings of a phase.
+func (c configuration) settings(phase string) phaseSettings {
+	if phase == phaseTranscription {
+		return c.Transcription
+	}
+	return c.Synthesis
+}
+
+// validate checks the settings of every phase.
+func (c configuration) validate() error {
+	var errs []error
+	if len(c.models(phaseTranscription)) == 0 || len(c.models(phaseSynthesis)) == 0 {
+		errs = append(errs, errors.New("no model set, GEMINI_MODEL is empty"))
+	}
+	errs = append(errs,
+		c.Transcription.validate("GEMINI_TRANSCRIPTION"),
+		c.Synthesis.validate("GEMINI_SYNTHESIS"),
+	)
+	return errors.Join(errs...)
+}
+
+// forPhase returns g with the model chain and the settings of the phase.
+func (g generation) forPhase(config configuration, phase string) generation {
+	g = g.withModels(config.models(phase))
+	g.settings = config.settings(phase)
+	return g
+}
+
+// joinInstructions joins the non empty system instructions.
+func joinInstructions(instructions ...string) string {
+	var parts []string
+	for _, instruction := range instructions {
+		if instruction = strings.TrimSpace(instruction); instruction != "" {
+			parts = append(parts, instruction)
+		}
+	}
+	return strings.Join(parts, "\n\n")
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/vertexai/genai"
+	"github.com/kelseyhightower/envconfig"
+)
+
+// TestPhaseSettingsFromEnv tests reading the settings of the phases
+func TestPhaseSettingsFromEnv(t *testing.T) {
+	t.Setenv("GCP_PROJECT", "p")
+	t.Setenv("GEMINI_TRANSCRIPTION_MODEL", "gemini-2.0-flash-lite")
+	t.Setenv("GEMINI_TRANSCRIPTION_TEMPERATURE", "0.1")
+	t.Setenv("GEMINI_SYNTHESIS_MAX_OUTPUT_TOKENS", "16384")
+	t.Setenv("GEMINI_SYNTHESIS_TOP_K", "20")
+
+	var config configuration
+	if err := envconfig.Process("", &config); err != nil {
+		t.Fatalf("envconfig.Process failed: %v", err)
+	}
+	if err := config.validate(); err != nil {
+		t.Fatalf("Expected a valid configuration, got %v", err)
+	}
+
+	transcription := config.settings(phaseTranscription)
+	if transcription.Model != "gemini-2.0-flash-lite" || transcription.Temperature != 0.1 || transcription.MaxOutputTokens != nil {
+		t.Errorf("Unexpected transcription settings: %+v", transcription)
+	}
+	synthesis := config.settings(phaseSynthesis)
+	if synthesis.Temperature != 0.4 || synthesis.TopP != nil || synthesis.TopK == nil || *synthesis.TopK != 20 {
+		t.Errorf("Unexpected synthesis settings: %+v", synthesis)
+	}
+
+	model := &genai.GenerativeModel{}
+	synthesis.apply(model)
+	if *model.Temperature != 0.4 || *model.MaxOutputTokens != 16384 || *model.TopK != 20 || model.TopP != nil {
+		t.Errorf("Unexpected model settings: %+v", model.GenerationConfig)
+	}
+
+	gen := generation{}.forPhase(config, phaseSynthesis)
+	if gen.model != "gemini-2.0-flash" || gen.settings.TopK == nil {
+		t.Errorf("Unexpected synthesis generation: %q %+v", gen.model, gen.settings)
+	}
+}
+
+// TestPhaseSettingsValidate tests the errors of invalid settings
+func TestPhaseSettingsValidate(t *testing.T) {
+	topP, topK := float32(1.5), int32(0)
+	config := configuration{
+		GeminiModel:   "gemini-2.0-flash",
+		Transcription: phaseSettings{Temperature: 3},
+		Synthesis:     phaseSettings{TopP: &topP, TopK: &topK},
+	}
+	err := config.validate()
+	if err == nil {
+		t.Fatal("Expected an error")
+	}
+	for _, expected := range []string{"GEMINI_TRANSCRIPTION_TEMPERATURE", "GEMINI_SYNTHESIS_TOP_P", "GEMINI_SYNTHESIS_TOP_K"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected the error to mention %s, got %v", expected, err)
+		}
+	}
+
+	if err := (configuration{}).validate(); err == nil || !strings.Contains(err.Error(), "no model") {
+		t.Errorf("Expected an error for a missing model, got %v", err)
+	}
+}
+
+// TestJoinInstructions tests the combination of the system instructions
+func TestJoinInstructions(t *testing.T) {
+	if got := joinInstructions("  Be concise. ", "", "<document/>"); got != "Be concise.\n\n<document/>" {
+		t.Errorf("Unexpected instructions: %q", got)
+	}
+}
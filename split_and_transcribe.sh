@@ -74,6 +74,7 @@ echo "Created ${#CHUNK_FILES[@]} chunks"
 
 # Transcribe all chunks
 echo "Transcribing chunks..."
-./audiotranscribe "${CHUNK_FILES[@]}" | tee "$OUTPUT_FILE"
+echo "Follow the progress with: tail -f ${OUTPUT_FILE}.progress"
+./audiotranscribe -o "$OUTPUT_FILE" "${CHUNK_FILES[@]}"
 
 echo "Transcription complete. Output saved to: $OUTPUT_FILE"
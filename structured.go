@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"cloud.google.com/go/vertexai/genai"
+)
+
+// structuredNote is added to the summary prompt when the synthesis follows
+// structuredSchema.
+const structuredNote = `
+
+Answer with a JSON object following the response schema instead of markdown: the themes of the synthesis with their summary, the key takeaways, the pitfalls (potential issues), the action items with their owner and due date when they are mentioned, the open questions, and a few key quotes copied word for word from the transcripts with their file (as in the <transcript file="..."> tags) and speaker. The text of the fields may use inline markdown.`
+
+// structuredSchema is the schema of a structured synthesis.
+var structuredSchema = &genai.Schema{
+	Type: genai.TypeObject,
+	Properties: map[string]*genai.Schema{
+		"themes": {
+			Type: genai.TypeArray,
+			Items: &genai.Schema{
+				Type: genai.TypeObject,
+				Properties: map[string]*genai.Schema{
+					"title":   {Type: genai.TypeString},
+					"summary": {Type: genai.TypeString},
+				},
+				Required: []string{"title", "summary"},
+			},
+		},
+		"takeaways": {Type: genai.TypeArray, Items: &genai.Schema{Type: genai.TypeString}},
+		"pitfalls":  {Type: genai.TypeArray, Items: &genai.Schema{Type: genai.TypeString}},
+		"action_items": {
+			Type: genai.TypeArray,
+			Items: &genai.Schema{
+				Type: genai.TypeObject,
+				Properties: map[string]*genai.Schema{
+					"description": {Type: genai.TypeString},
+					"owner":       {Type: genai.TypeString},
+					"due":         {Type: genai.TypeString},
+				},
+				Required: []string{"description"},
+			},
+		},
+		"open_questions": {Type: genai.TypeArray, Items: &genai.Schema{Type: genai.TypeString}},
+		"quotes":         quotesSchema,
+	},
+	Required: []string{"themes", "takeaways", "pitfalls", "action_items", "open_questions", "quotes"},
+}
+
+// structuredSummary is a synthesis following structuredSchema.
+type structuredSummary struct {
+	Themes        []theme      `json:"themes"`
+	Takeaways     []string     `json:"takeaways"`
+	Pitfalls      []string     `json:"pitfalls"`
+	ActionItems   []actionItem `json:"action_items"`
+	OpenQuestions []string     `json:"open_questions"`
+	Quotes        []quote      `json:"quotes"`
+}
+
+// theme is a theme of a structured synthesis.
+type theme struct {
+	Title   string `json:"title"`
+	Summary string `json:"summary"`
+}
+
+// actionItem is a task decided or suggested in the recordings.
+type actionItem struct {
+	Description string `json:"description"`
+	Owner       string `json:"owner,omitempty"`
+	Due         string `json:"due,omitempty"`
+}
+
+// parseStructuredSummary decodes and validates a structured synthesis. The
+// empty entries are dropped.
+func parseStructuredSummary(text string) (*structuredSummary, error) {
+	var s structuredSummary
+	dec := json.NewDecoder(strings.NewReader(text))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&s); err != nil {
+		return nil, fmt.Errorf("invalid structured synthesis: %w", err)
+	}
+
+	themes := s.Themes[:0]
+	for _, t := range s.Themes {
+		t.Title, t.Summary = strings.TrimSpace(t.Title), strings.TrimSpace(t.Summary)
+		if t.Title != "" || t.Summary != "" {
+			themes = append(themes, t)
+		}
+	}
+	s.Themes = themes
+	items := s.ActionItems[:0]
+	for _, a := range s.ActionItems {
+		a.Description, a.Owner, a.Due = strings.TrimSpace(a.Description), strings.TrimSpace(a.Owner), strings.TrimSpace(a.Due)
+		if a.Description != "" {
+			items = append(items, a)
+		}
+	}
+	s.ActionItems = items
+	quotes := s.Quotes[:0]
+	for _, q := range s.Quotes {
+		if q.Text = strings.TrimSpace(q.Text); q.Text != "" {
+			quotes = append(quotes, q)
+		}
+	}
+	s.Quotes = quotes
+	s.Takeaways = nonEmpty(s.Takeaways)
+	s.Pitfalls = nonEmpty(s.Pitfalls)
+	s.OpenQuestions = nonEmpty(s.OpenQuestions)
+
+	if len(s.Themes) == 0 && len(s.Takeaways) == 0 {
+		return nil, errors.New("invalid structured synthesis: no theme and no takeaway")
+	}
+	return &s, nil
+}
+
+// nonEmpty returns the trimmed non empty strings of list.
+func nonEmpty(list []string) []string {
+	var kept []string
+	for _, s := range list {
+		if s = strings.TrimSpace(s); s != "" {
+			kept = append(kept, s)
+		}
+	}
+	return kept
+}
+
+// structuredTemplate renders a structured synthesis as the markdown of the
+// Synthesis field of the report.
+var structuredTemplate = template.Must(template.New("structured").Funcs(reportFuncs).Parse(`
+{{- with .Themes }}## Themes
+{{ range . }}
+### {{ .Title }}
+
+{{ .Summary }}
+{{ end }}
+{{ end }}
+{{- with .Takeaways }}## Key Takeaways
+
+{{ range . }}- {{ . }}
+{{ end }}
+{{ end }}
+{{- with .Pitfalls }}## Potential Issues
+
+{{ range . }}- {{ . }}
+{{ end }}
+{{ end }}
+{{- with .ActionItems }}## Action Items
+
+{{ range . }}- [ ] {{ .Description }}{{ with .Owner }} (owner: {{ . }}){{ end }}{{ with .Due }}, due {{ . }}{{ end }}
+{{ end }}
+{{ end }}
+{{- with .OpenQuestions }}## Open Questions
+
+{{ range . }}- {{ . }}
+{{ end }}
+{{ end }}`))
+
+// markdown renders the structured synthesis as markdown.
+func (s *structuredSummary) markdown() (string, error) {
+	var b strings.Builder
+	if err := structuredTemplate.Execute(&b, s); err != nil {
+		return "", fmt.Errorf("failed to render the structured synthesis: %w", err)
+	}
+	return strings.TrimSpace(b.String()), nil
+}
+
+// rawStructured returns the synthesis the model returned when it cannot be
+// read as a structured synthesis: the response as a JSON block, after a note
+// giving the reason, so that the report is still saved.
+func rawStructured(text string, err error) string {
+	return fmt.Sprintf("> **The structured synthesis could not be read** (%v); the response of the model is kept as is.\n\n```json\n%s\n```", err, strings.TrimSpace(text))
+}
+
+// jsonReport is the JSON export of a report. Its sources can be read back
+// by loadTranscripts.
+type jsonReport struct {
+	Title     string             `json:"title"`
+	Date      time.Time          `json:"date"`
+	Model     string             `json:"model"`
+	Sources   []jsonSource       `json:"sources"`
+	Synthesis string             `json:"synthesis,omitempty"`
+	Summary   *structuredSummary `json:"summary,omitempty"`
+	Quotes    []quote            `json:"quotes,omitempty"`
+	Themes    []themeCluster     `json:"themes,omitempty"`
+	Personas  *personaSet        `json:"personas,omitempty"`
+	Minutes   *minutes           `json:"minutes,omitempty"`
+	Coverage  *coverageMatrix    `json:"coverage,omitempty"`
+	Usage     tokenUsage         `json:"usage"`
+	CostUSD   float64            `json:"cost_usd"`
+	Stopped   string             `json:"stopped,omitempty"`
+	Failed    []failedPass       `json:"failed,omitempty"`
+}
+
+// jsonSource is a transcript of the JSON export.
+type jsonSource struct {
+	File       string `json:"file"`
+	Model      string `json:"model,omitempty"`
+	Duration   string `json:"duration,omitempty"`
+	Transcript string `json:"transcript"`
+}
+
+// writeJSON exports the report as JSON to path.
+func (r report) writeJSON(path string) error {
+	export := jsonReport{
+		Title:     r.Title,
+		Date:      r.Date,
+		Model:     r.Model,
+		Synthesis: r.Synthesis,
+		Summary:   r.Structured,
+		Quotes:    r.Quotes,
+		Themes:    r.Themes,
+		Personas:  r.Personas,
+		Minutes:   r.Minutes,
+		Coverage:  r.Coverage,
+		Usage:     r.Usage,
+		CostUSD:   r.Cost,
+		Stopped:   r.Stopped,
+		Failed:    r.Failed,
+	}
+	for _, src := range r.Sources {
+		s := jsonSource{File: src.Path, Model: src.Model, Transcript: src.Transcript}
+		if src.Duration > 0 {
+			s.Duration = src.Duration.String()
+		}
+		export.Sources = append(export.Sources, s)
+	}
+	content, err := json.MarshalIndent(export, "", "  ")
+	if err != nil {
+		return fmt.Errorf("json.Marshal: %w", err)
+	}
+	f, err := createAtomic(path, false)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(content, '\n')); err != nil {
+		f.Abort()
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return f.Commit()
+}
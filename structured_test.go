@@ -0,0 +1,91 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testStructuredSummary = `{
+  "themes": [{"title": "Checkout", "summary": "Checkout is **slow** on mobile."}, {"title": " ", "summary": ""}],
+  "takeaways": ["Speed up the checkout", ""],
+  "pitfalls": ["Card only"],
+  "action_items": [{"description": "Profile the payment page", "owner": "Alice", "due": "next sprint"}, {"description": "Survey users"}],
+  "open_questions": [],
+  "quotes": [{"quote": "way too slow", "file": "interview1.m4a", "speaker": "Speaker A"}]
+}`
+
+// TestParseStructuredSummary tests the validation and the markdown rendering of a structured synthesis
+func TestParseStructuredSummary(t *testing.T) {
+	s, err := parseStructuredSummary(testStructuredSummary)
+	if err != nil {
+		t.Fatalf("parseStructuredSummary failed: %v", err)
+	}
+	if len(s.Themes) != 1 || len(s.Takeaways) != 1 || len(s.ActionItems) != 2 || len(s.Quotes) != 1 {
+		t.Errorf("Expected the empty entries to be dropped, got %+v", s)
+	}
+
+	md, err := s.markdown()
+	if err != nil {
+		t.Fatalf("markdown failed: %v", err)
+	}
+	t.Logf("Output:\n%s", md)
+	for _, expected := range []string{
+		"## Themes\n\n### Checkout\n\nCheckout is **slow** on mobile.\n\n## Key Takeaways\n\n- Speed up the checkout\n\n## Potential Issues\n",
+		"- [ ] Profile the payment page (owner: Alice), due next sprint\n- [ ] Survey users",
+	} {
+		if !strings.Contains(md, expected) {
+			t.Errorf("Expected markdown to contain %q", expected)
+		}
+	}
+	if strings.Contains(md, "Open Questions") {
+		t.Error("Expected the empty sections to be left out")
+	}
+
+	for _, invalid := range []string{
+		`{"themes": [], "takeaways": [" "]}`,
+		`{"takeaways": ["a"], "risks": []}`,
+		"## Key Takeaways\n- not JSON",
+	} {
+		if _, err := parseStructuredSummary(invalid); err == nil {
+			t.Errorf("Expected an error for %q", invalid)
+		}
+	}
+}
+
+// TestWriteJSON tests that the transcripts of the JSON export can be summarized again
+func TestWriteJSON(t *testing.T) {
+	rep := testReport()
+	var err error
+	if rep.Structured, err = parseStructuredSummary(testStructuredSummary); err != nil {
+		t.Fatalf("parseStructuredSummary failed: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "report.json")
+	if err := rep.writeJSON(path); err != nil {
+		t.Fatalf("writeJSON failed: %v", err)
+	}
+
+	sources, err := loadTranscripts(path)
+	if err != nil {
+		t.Fatalf("loadTranscripts failed: %v", err)
+	}
+	if len(sources) != 2 || sources[0].Path != "/tmp/chunk_000.m4a" || sources[1].Transcript != rep.Sources[1].Transcript {
+		t.Errorf("Unexpected sources read back: %+v", sources)
+	}
+}
+
+// TestRawStructured tests that an invalid structured synthesis is kept with a note
+func TestRawStructured(t *testing.T) {
+	text := `{"themes": [`
+	_, err := parseStructuredSummary(text)
+	if err == nil {
+		t.Fatal("Expected an invalid structured synthesis")
+	}
+	got := rawStructured(text+"\n", err)
+	if !strings.HasPrefix(got, "> **The structured synthesis could not be read** (invalid structured synthesis: ") {
+		t.Errorf("Expected a note with the reason, got %q", got)
+	}
+	if !strings.HasSuffix(got, "\n\n```json\n"+text+"\n```") {
+		t.Errorf("Expected the raw response in a JSON block, got %q", got)
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"unicode/utf8"
+
+	"cloud.google.com/go/vertexai/genai"
+)
+
+const (
+	// transcriptSeparator separates the transcripts given to the synthesis.
+	transcriptSeparator = "\n\n---\n\n"
+
+	// maxSummaryDepth bounds the number of map-reduce levels.
+	maxSummaryDepth = 4
+
+	// partialSummaryPrompt is used to summarize a transcript, or a part of a
+	// transcript, on its own before the final synthesis.
+	partialSummaryPrompt = `Summarize this transcript, or part of a transcript. This partial summary will be merged with others into a global synthesis, so:
+
+1. Keep every idea, insight, problem and solution discussed, with the speaker who expressed it
+2. Keep the figures, names and short verbatim quotes that support them
+3. Do not add an introduction or a conclusion
+4. Write in the same language as the transcript
+
+Provide the partial summary as markdown bullet points.`
+
+	// partialSummariesNote is added to the prompt of the final synthesis
+	// when it is made from partial summaries.
+	partialSummariesNote = "\n\nNote: the content below is made of partial summaries of the transcripts, not of the transcripts themselves."
+)
+
+// summarizer produces the synthesis of a set of transcripts. When the input
+// does not fit in tokenLimit, it switches to a map-reduce mode: every
+// transcript (split into chunks if needed) is summarized on its own, and the
+// partial summaries are then synthesized. This is repeated up to
+// maxSummaryDepth levels.
+type summarizer struct {
+	client *genai.Client
+	// systemInstruction frames every call, if not empty.
+	systemInstruction string
+	prompt            string
+	tokenLimit        int32
+	// w receives the partial summaries and the synthesis as they are
+	// produced, token by token if stream is set.
+	w io.Writer
+	// gen holds the model chain, the streaming setting and the ledger. Once
+	// a model of the chain falls back, the next calls use the fallback.
+	gen generation
+	// schema, if not nil, is the response schema of the synthesis; the
+	// partial summaries are free text.
+	schema *genai.Schema
+	// usage accumulates the token usage of every call.
+	usage tokenUsage
+}
+
+// newModel returns the model of gen, with the system instruction added to
+// the one of the settings.
+func (s *summarizer) newModel(gen generation) *genai.GenerativeModel {
+	model := gen.newModel(s.client)
+	if s.systemInstruction != "" {
+		model.SystemInstruction = genai.NewUserContent(genai.Text(joinInstructions(gen.settings.SystemInstruction, s.systemInstruction)))
+	}
+	return model
+}
+
+func (s *summarizer) summarize(ctx context.Context, transcripts []string) (string, error) {
+	return s.reduce(ctx, s.prompt, "", transcripts, 0)
+}
+
+// reduce summarizes the inputs with prompt and writes the summary to s.w.
+// chunk identifies a partial summary, it is empty for the synthesis.
+func (s *summarizer) reduce(ctx context.Context, prompt, chunk string, inputs []string, depth int) (string, error) {
+	combined := strings.Join(inputs, transcriptSeparator)
+
+	res, err := s.newModel(s.gen).CountTokens(ctx, genai.Text(prompt), genai.Text(combined))
+	if err != nil {
+		logger.Warn("unable to count tokens, summarizing in a single call", "error", err)
+		res = &genai.CountTokensResponse{}
+	}
+	if res.TotalTokens <= s.tokenLimit {
+		estimate := tokenUsage{Prompt: res.TotalTokens, Candidates: synthesisOutputTokens}
+		if estimate.Prompt == 0 {
+			estimate.Prompt = int32((len(prompt) + len(combined)) / 4)
+		}
+		estimate.Total = estimate.Prompt + estimate.Candidates
+		return s.generate(ctx, chunk, estimate, genai.Text(prompt), genai.Text(combined))
+	}
+	if depth >= maxSummaryDepth {
+		return "", fmt.Errorf("input still too large after %d levels of summaries: %d tokens (limit %d)", depth, res.TotalTokens, s.tokenLimit)
+	}
+
+	logger.Info("input too large, summarizing hierarchically", "tokens", res.TotalTokens, "limit", s.tokenLimit, "level", depth+1)
+
+	// Map: summarize every transcript, or chunk of transcript, on its own.
+	parts := splitInputs(inputs, res.TotalTokens, s.tokenLimit)
+	partials := make([]string, 0, len(parts))
+	for i, part := range parts {
+		logger.Info("summarizing part", "part", fmt.Sprintf("%d/%d", i+1, len(parts)), "level", depth+1)
+		chunk := fmt.Sprintf("%d/%d", i+1, len(parts))
+		partial, err := s.reduce(ctx, partialSummaryPrompt, chunk, []string{part}, depth+1)
+		if err != nil {
+			return "", fmt.Errorf("unable to summarize part %d/%d: %w", i+1, len(parts), err)
+		}
+		partials = append(partials, partial)
+	}
+
+	// Reduce: synthesize the partial summaries.
+	if !strings.HasSuffix(prompt, partialSummariesNote) && prompt != partialSummaryPrompt {
+		prompt += partialSummariesNote
+	}
+	return s.reduce(ctx, prompt, chunk, partials, depth+1)
+}
+
+// generate runs a single summary call and writes its result to s.w. The call
+// is not made if its estimated usage exceeds the budget of the run.
+func (s *summarizer) generate(ctx context.Context, chunk string, estimate tokenUsage, parts ...genai.Part) (string, error) {
+	phase, header := phaseSynthesis, "\n\nSynthesis:\n"
+	if chunk != "" {
+		phase, header = phasePartialSummary, fmt.Sprintf("\n\nPartial summary %s:\n", chunk)
+	}
+	if err := s.gen.check(phase, estimate); err != nil {
+		return "", err
+	}
+	if _, err := io.WriteString(s.w, header); err != nil {
+		return "", fmt.Errorf("failed to write summary: %w", err)
+	}
+	var stream io.Writer
+	if s.gen.stream {
+		stream = s.w
+	}
+	var text string
+	gen, err := s.gen.withFallback(func(gen generation) error {
+		var (
+			usage tokenUsage
+			err   error
+		)
+		model := s.newModel(gen)
+		if chunk == "" && s.schema != nil {
+			model.ResponseMIMEType = "application/json"
+			model.ResponseSchema = s.schema
+		}
+		text, usage, err = generateText(ctx, model, stream, gen.checker(phase), parts...)
+		s.usage.add(usage)
+		gen.record(phase, "", chunk, usage)
+		return err
+	})
+	s.gen = gen
+	if err != nil {
+		return "", err
+	}
+	if !s.gen.stream {
+		io.WriteString(s.w, text)
+	}
+	io.WriteString(s.w, "\n")
+	return text, flush(s.w)
+}
+
+// splitInputs splits the inputs whose estimated size exceeds three quarters
+// of limit. The size of an input is estimated from its share of the total
+// number of tokens.
+func splitInputs(inputs []string, tokens, limit int32) []string {
+	total := 0
+	for _, input := range inputs {
+		total += len(input)
+	}
+	if total == 0 {
+		return inputs
+	}
+	target := float64(limit) * 3 / 4
+	var parts []string
+	for _, input := range inputs {
+		estimate := float64(tokens) * float64(len(input)) / float64(total)
+		n := int(math.Ceil(estimate / target))
+		parts = append(parts, splitText(input, n)...)
+	}
+	return parts
+}
+
+// splitText splits text into about n pieces of similar size, cutting at line
+// breaks, or at spaces for very long lines.
+func splitText(text string, n int) []string {
+	if n <= 1 {
+		return []string{text}
+	}
+	size := len(text)/n + 1
+	var pieces []string
+	for len(text) > size {
+		cut := strings.LastIndexByte(text[:size], '\n')
+		if cut <= 0 {
+			cut = strings.LastIndexByte(text[:size], ' ')
+		}
+		if cut <= 0 {
+			cut = size
+			for cut < len(text) && !utf8.RuneStart(text[cut]) {
+				cut++
+			}
+		}
+		if piece := strings.TrimSpace(text[:cut]); piece != "" {
+			pieces = append(pieces, piece)
+		}
+		text = text[cut:]
+	}
+	if piece := strings.TrimSpace(text); piece != "" {
+		pieces = append(pieces, piece)
+	}
+	return pieces
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// TestSplitText tests that pieces are cut at line breaks and nothing is lost
+func TestSplitText(t *testing.T) {
+	var lines []string
+	for i := 0; i < 100; i++ {
+		lines = append(lines, fmt.Sprintf("Speaker A: sentence number %03d", i))
+	}
+	text := strings.Join(lines, "\n")
+
+	pieces := splitText(text, 4)
+	if len(pieces) < 4 || len(pieces) > 5 {
+		t.Errorf("Expected about 4 pieces, got %d", len(pieces))
+	}
+	for i, piece := range pieces {
+		if !strings.HasPrefix(piece, "Speaker A: sentence number") {
+			t.Errorf("Piece %d is not cut at a line break: %q", i, piece[:30])
+		}
+	}
+	if rejoined := strings.Join(pieces, "\n"); rejoined != text {
+		t.Error("Pieces do not rebuild the original text")
+	}
+}
+
+// TestSplitTextLongLine tests splitting a text without line breaks or spaces
+func TestSplitTextLongLine(t *testing.T) {
+	text := strings.Repeat("é", 100)
+	pieces := splitText(text, 3)
+	if len(pieces) < 3 {
+		t.Errorf("Expected at least 3 pieces, got %d", len(pieces))
+	}
+	if strings.Join(pieces, "") != text {
+		t.Error("Pieces do not rebuild the original text, a rune may have been cut")
+	}
+}
+
+// TestSplitTextSingle tests that a text is kept whole when one piece is asked
+func TestSplitTextSingle(t *testing.T) {
+	pieces := splitText("whole text", 1)
+	if len(pieces) != 1 || pieces[0] != "whole text" {
+		t.Errorf("Expected the whole text, got %q", pieces)
+	}
+}
+
+// TestSplitInputs tests that only the inputs too large for the limit are split
+func TestSplitInputs(t *testing.T) {
+	small := strings.Repeat("small line\n", 10)
+	large := strings.Repeat("large line\n", 90)
+
+	// 1000 tokens for the whole input: about 100 for small, 900 for large.
+	parts := splitInputs([]string{small, large}, 1000, 400)
+	if parts[0] != small {
+		t.Errorf("Expected the small input to be kept whole, got %q", parts[0])
+	}
+	// 900 estimated tokens with a target of 300 per part.
+	if len(parts) < 1+3 || len(parts) > 1+4 {
+		t.Errorf("Expected the large input to be split in 3 or 4 parts, got %d parts in total", len(parts))
+	}
+	if strings.Join(parts[1:], "\n")+"\n" != large {
+		t.Error("Parts do not rebuild the large input")
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"cloud.google.com/go/vertexai/genai"
+)
+
+// themesPrompt asks for the themes shared across the interviews and the
+// interviews supporting them.
+const themesPrompt = `Group the findings of the interview transcripts below into themes. For every theme:
+
+1. Give a short title and a one or two sentence summary
+2. List every transcript in which the theme comes up, with its file as in its <transcript file="..."> tag, the speakers who raise it as labelled in the transcript (an empty list if unknown), and the quote of the transcript that best represents the theme, copied word for word
+3. Keep a theme raised in a single interview: how widespread a theme is matters as much as the theme itself
+
+Write the titles and summaries in the language of the interviews.`
+
+// themesSchema is the schema of the response to themesPrompt.
+var themesSchema = &genai.Schema{
+	Type: genai.TypeArray,
+	Items: &genai.Schema{
+		Type: genai.TypeObject,
+		Properties: map[string]*genai.Schema{
+			"title":   {Type: genai.TypeString},
+			"summary": {Type: genai.TypeString},
+			"sources": {
+				Type: genai.TypeArray,
+				Items: &genai.Schema{
+					Type: genai.TypeObject,
+					Properties: map[string]*genai.Schema{
+						"file":     {Type: genai.TypeString},
+						"speakers": {Type: genai.TypeArray, Items: &genai.Schema{Type: genai.TypeString}},
+						"quote":    {Type: genai.TypeString},
+					},
+					Required: []string{"file", "speakers", "quote"},
+				},
+			},
+		},
+		Required: []string{"title", "summary", "sources"},
+	},
+}
+
+// themeCluster is a theme of the interviews and the interviews in which it
+// comes up, one source per interview.
+type themeCluster struct {
+	Title   string        `json:"title"`
+	Summary string        `json:"summary"`
+	Sources []themeSource `json:"sources"`
+}
+
+// themeSource is an interview supporting a theme.
+type themeSource struct {
+	File     string   `json:"file"`
+	Speakers []string `json:"speakers,omitempty"`
+	Quote    string   `json:"quote,omitempty"`
+	// Verified is true if the quote was found in the transcript.
+	Verified bool `json:"verified"`
+}
+
+// Representative returns the source whose quote represents the theme: the
+// first verified quote, or the first quote if none is verified. It returns
+// nil if the theme has no quote.
+func (t themeCluster) Representative() *themeSource {
+	var first *themeSource
+	for i := range t.Sources {
+		s := &t.Sources[i]
+		if s.Quote == "" {
+			continue
+		}
+		if s.Verified {
+			return s
+		}
+		if first == nil {
+			first = s
+		}
+	}
+	return first
+}
+
+// clusterThemes asks the model for the themes of the sources and the
+// interviews supporting each, and writes them to w. The synthesis, if any,
+// is given as a starting point. When the transcripts exceed tokenLimit
+// tokens, the themes are clustered one transcript at a time; the titles
+// found so far are given with the next transcripts so that the themes can
+// be merged by title. The themes are sorted by the number of interviews
+// supporting them.
+func clusterThemes(w io.Writer, gen generation, systemInstruction, synthesis string, sources []source, tokenLimit int32) ([]themeCluster, error) {
+	ctx := context.Background()
+	client, err := genai.NewClient(ctx, gen.projectID, gen.location)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create client: %w", err)
+	}
+	defer client.Close()
+
+	parts := []genai.Part{genai.Text(themesPrompt)}
+	if synthesis != "" {
+		parts = append(parts, genai.Text("Synthesis of the interviews, whose themes are a starting point:\n"+synthesis))
+	}
+	j := jsonGenerator{client: client, gen: gen, systemInstruction: systemInstruction, schema: themesSchema, phase: phaseThemes}
+	groups, tokens := j.groups(ctx, sources, tokenLimit, parts...)
+
+	var themes []themeCluster
+	for _, group := range groups {
+		request := append([]genai.Part{}, parts...)
+		if len(themes) > 0 {
+			var known strings.Builder
+			known.WriteString("Themes found in the other interviews; reuse their exact title when a finding belongs to one of them:\n")
+			for _, t := range themes {
+				fmt.Fprintf(&known, "- %s\n", t.Title)
+			}
+			request = append(request, genai.Text(known.String()))
+		}
+		request = append(request, genai.Text(formatCorpus(group)))
+		var results []themeCluster
+		estimate := tokens * int32(len(group)) / int32(len(sources))
+		if err := j.generate(ctx, estimate, &results, request...); err != nil {
+			return themes, err
+		}
+		themes = mergeThemes(themes, verifyThemes(results, group))
+	}
+	sort.SliceStable(themes, func(a, b int) bool {
+		return len(themes[a].Sources) > len(themes[b].Sources)
+	})
+
+	if _, err := io.WriteString(w, "\n\nThemes:\n"); err != nil {
+		return themes, fmt.Errorf("failed to write themes: %w", err)
+	}
+	for _, t := range themes {
+		fmt.Fprintf(w, "- %s: %d/%d interviews\n", t.Title, len(t.Sources), len(sources))
+	}
+	return themes, flush(w)
+}
+
+// verifyThemes attributes the sources of the themes to the transcripts and
+// looks for their quote in the transcript of their file, as verifyQuotes
+// does. A source whose file is not one of the transcripts is dropped, and
+// so is a theme left without source.
+func verifyThemes(themes []themeCluster, sources []source) []themeCluster {
+	var verified []themeCluster
+	for _, t := range themes {
+		t.Title, t.Summary = strings.TrimSpace(t.Title), strings.TrimSpace(t.Summary)
+		kept := t.Sources[:0]
+		for _, s := range t.Sources {
+			src, ok := findSource(sources, s.File)
+			if !ok {
+				logger.Warn("ignoring the source of a theme not in the transcripts", "theme", t.Title, "file", s.File)
+				continue
+			}
+			s.File = src.label()
+			s.Speakers = nonEmpty(s.Speakers)
+			if s.Quote = strings.TrimSpace(s.Quote); s.Quote != "" {
+				s.Verified = verifyQuotes([]quote{{Text: s.Quote, File: s.File}}, []source{src}, quotesFlag)[0].Verified
+			}
+			kept = append(kept, s)
+		}
+		t.Sources = kept
+		if t.Title == "" || len(t.Sources) == 0 {
+			continue
+		}
+		verified = append(verified, t)
+	}
+	return verified
+}
+
+// mergeThemes adds the themes to the clusters, merging the themes with the
+// same title, whatever its case, and their sources with the same file.
+func mergeThemes(clusters, themes []themeCluster) []themeCluster {
+	for _, t := range themes {
+		i := 0
+		for i < len(clusters) && !strings.EqualFold(clusters[i].Title, t.Title) {
+			i++
+		}
+		if i == len(clusters) {
+			clusters = append(clusters, themeCluster{Title: t.Title, Summary: t.Summary})
+		}
+		c := &clusters[i]
+		for _, s := range t.Sources {
+			k := 0
+			for k < len(c.Sources) && c.Sources[k].File != s.File {
+				k++
+			}
+			if k == len(c.Sources) {
+				c.Sources = append(c.Sources, s)
+				continue
+			}
+			existing := &c.Sources[k]
+			for _, speaker := range s.Speakers {
+				if !containsFold(existing.Speakers, speaker) {
+					existing.Speakers = append(existing.Speakers, speaker)
+				}
+			}
+			if s.Verified && !existing.Verified || existing.Quote == "" {
+				existing.Quote, existing.Verified = s.Quote, s.Verified
+			}
+		}
+	}
+	return clusters
+}
+
+// containsFold reports whether list contains s, whatever its case.
+func containsFold(list []string, s string) bool {
+	for _, e := range list {
+		if strings.EqualFold(e, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestVerifyThemes tests the attribution of the sources to the transcripts and the verification of their quotes
+func TestVerifyThemes(t *testing.T) {
+	sources := []source{
+		{Path: "/tmp/interview1.m4a", Transcript: "Speaker A: honestly the checkout is way too slow on my phone"},
+		{Path: "/tmp/interview2.m4a", Transcript: "Speaker B: I pay with my card, it is fine"},
+	}
+	themes := verifyThemes([]themeCluster{
+		{Title: " Slow checkout ", Summary: "Checkout is slow.", Sources: []themeSource{
+			{File: "interview1.m4a", Speakers: []string{"Speaker A", " "}, Quote: "the checkout is way too slow on my phone"},
+			{File: "interview2.m4a", Speakers: []string{"Speaker B"}, Quote: "checkout takes forever"},
+			{File: "interview3.m4a", Quote: "slow"},
+		}},
+		{Title: "Hallucinated", Sources: []themeSource{{File: "interview9.m4a", Quote: "nothing"}}},
+	}, sources)
+
+	expected := []themeCluster{
+		{Title: "Slow checkout", Summary: "Checkout is slow.", Sources: []themeSource{
+			{File: "interview1.m4a", Speakers: []string{"Speaker A"}, Quote: "the checkout is way too slow on my phone", Verified: true},
+			{File: "interview2.m4a", Speakers: []string{"Speaker B"}, Quote: "checkout takes forever"},
+		}},
+	}
+	if !reflect.DeepEqual(themes, expected) {
+		t.Errorf("verifyThemes() = %+v, expected %+v", themes, expected)
+	}
+}
+
+// TestVerifyThemesSameFileName tests that the transcripts with the same file name are told apart
+func TestVerifyThemesSameFileName(t *testing.T) {
+	sources := []source{
+		{Path: "out/a/chunk_000.m4a", Transcript: "Speaker A: the checkout is way too slow"},
+		{Path: "out/b/chunk_000.m4a", Transcript: "Speaker A: I love the new checkout"},
+	}
+	labelSources(sources)
+	themes := verifyThemes([]themeCluster{
+		{Title: "Checkout", Sources: []themeSource{
+			{File: "b/chunk_000.m4a", Quote: "I love the new checkout"},
+			{File: "a/chunk_000.m4a", Quote: "the checkout is way too slow"},
+		}},
+	}, sources)
+	if len(themes) != 1 || len(themes[0].Sources) != 2 {
+		t.Fatalf("Expected a theme with 2 sources, got %+v", themes)
+	}
+	for _, s := range themes[0].Sources {
+		if !s.Verified {
+			t.Errorf("Expected the quote of %s to be found in its transcript", s.File)
+		}
+	}
+
+	quotes := verifyQuotes([]quote{{Text: "the checkout is way too slow", File: "chunk_000.m4a"}}, sources, quotesFlag)
+	if quotes[0].File != "a/chunk_000.m4a" {
+		t.Errorf("Expected the quote to be attributed to a/chunk_000.m4a, got %q", quotes[0].File)
+	}
+}
+
+// TestDefaultReportTemplateThemesTableCell tests that the text of the model cannot break the themes table
+func TestDefaultReportTemplateThemesTableCell(t *testing.T) {
+	rep := testReport()
+	rep.Themes = []themeCluster{{Title: "Price | value", Sources: []themeSource{
+		{File: "chunk_000.m4a", Speakers: []string{"Speaker A"}, Quote: "too expensive |\nreally", Verified: true},
+	}}}
+	content := testRender(t, rep)
+	expected := "| **Price \\| value** | 1/2 | chunk_000.m4a (Speaker A) | \"too expensive \\| really\" (chunk_000.m4a) |\n"
+	if !strings.Contains(content, expected) {
+		t.Errorf("Expected report to contain %q, got:\n%s", expected, content)
+	}
+}
+
+// TestMergeThemes tests that the themes of several calls are merged by title, and their sources by file
+func TestMergeThemes(t *testing.T) {
+	clusters := mergeThemes(nil, []themeCluster{
+		{Title: "Slow checkout", Summary: "first", Sources: []themeSource{{File: "a.m4a", Speakers: []string{"Speaker A"}, Quote: "slow", Verified: false}}},
+	})
+	clusters = mergeThemes(clusters, []themeCluster{
+		{Title: "slow checkout", Summary: "second", Sources: []themeSource{
+			{File: "a.m4a", Speakers: []string{"speaker a", "Speaker C"}, Quote: "way too slow", Verified: true},
+			{File: "b.m4a", Quote: "slow too"},
+		}},
+		{Title: "Pricing", Sources: []themeSource{{File: "b.m4a"}}},
+	})
+
+	expected := []themeCluster{
+		{Title: "Slow checkout", Summary: "first", Sources: []themeSource{
+			{File: "a.m4a", Speakers: []string{"Speaker A", "Speaker C"}, Quote: "way too slow", Verified: true},
+			{File: "b.m4a", Quote: "slow too"},
+		}},
+		{Title: "Pricing", Sources: []themeSource{{File: "b.m4a"}}},
+	}
+	if !reflect.DeepEqual(clusters, expected) {
+		t.Errorf("mergeThemes() = %+v, expected %+v", clusters, expected)
+	}
+	if r := clusters[0].Representative(); r == nil || r.File != "a.m4a" {
+		t.Errorf("Representative() = %+v, expected the verified quote of a.m4a", r)
+	}
+	if r := clusters[1].Representative(); r != nil {
+		t.Errorf("Representative() = %+v, expected nil for a theme without quote", r)
+	}
+}
+
+// TestDefaultReportTemplateThemes tests the table of the themes with their frequency and attribution
+func TestDefaultReportTemplateThemes(t *testing.T) {
+	tmpl, err := loadReportTemplate("")
+	if err != nil {
+		t.Fatalf("failed to load default template: %v", err)
+	}
+	rep := testReport()
+	rep.Themes = []themeCluster{
+		{Title: "Slow checkout", Summary: "Checkout is too slow on mobile.", Sources: []themeSource{
+			{File: "chunk_000.m4a", Speakers: []string{"Speaker A", "Speaker C"}, Quote: "way too slow", Verified: true},
+			{File: "chunk_001.m4a", Quote: "slow too"},
+		}},
+		{Title: "Pricing", Summary: "Prices are unclear.", Sources: []themeSource{{File: "chunk_001.m4a", Speakers: []string{"Speaker B"}}}},
+	}
+	var buf bytes.Buffer
+	if err := rep.render(&buf, tmpl); err != nil {
+		t.Fatalf("render failed: %v", err)
+	}
+	for _, expected := range []string{
+		"## Themes across interviews\n\n| Theme | Interviews | Sources | Representative quote |\n|-------|------------|---------|----------------------|\n" +
+			"| **Slow checkout** | 2/2 | chunk_000.m4a (Speaker A, Speaker C); chunk_001.m4a | \"way too slow\" (chunk_000.m4a) |\n" +
+			"| **Pricing** | 1/2 | chunk_001.m4a (Speaker B) |  |\n",
+		"### Slow checkout\n\nCheckout is too slow on mobile.\n\n> way too slow\n>\n> — Speaker A, Speaker C, chunk_000.m4a\n\n> slow too\n>\n> — chunk_001.m4a *(not found in the transcript)*\n\n### Pricing\n\nPrices are unclear.\n",
+	} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("Expected report to contain %q, got:\n%s", expected, buf.String())
+		}
+	}
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// progressHeader starts every transcript written to the progress output by
+// transcribeAudio.
+const progressHeader = "Generated transcript for "
+
+// loadTranscripts reads the transcripts of a previous run from path:
+//   - a report rendered with the built-in template, whose "### file"
+//     sections under "## Transcripts" are the transcripts;
+//   - a progress file, made of "Generated transcript for file:" sections;
+//   - a JSON cache entry or list of entries, or an object with a sources
+//     list of {file, model, transcript} objects;
+//   - any other file, whose whole content is the transcript.
+func loadTranscripts(path string) ([]source, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read transcript: %w", err)
+	}
+	text := strings.TrimSpace(string(content))
+	if text == "" {
+		return nil, fmt.Errorf("empty transcript: %s", path)
+	}
+
+	var sources []source
+	switch {
+	case strings.EqualFold(filepath.Ext(path), ".json") || (text[0] == '{' || text[0] == '[') && json.Valid(content):
+		sources, err = parseJSONTranscripts(content)
+		if err != nil {
+			return nil, fmt.Errorf("invalid JSON transcripts %s: %w", path, err)
+		}
+	case strings.HasPrefix(text, progressHeader) || strings.Contains(text, "\n"+progressHeader):
+		sources = parseProgressTranscripts(text)
+	case hasLine(text, "## Transcripts"):
+		sources, err = parseReportTranscripts(text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid report %s: %w", path, err)
+		}
+	default:
+		return []source{{Path: path, Transcript: text}}, nil
+	}
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("no transcript found in %s", path)
+	}
+	return sources, nil
+}
+
+// hasLine reports whether text holds line.
+func hasLine(text, line string) bool {
+	return strings.HasPrefix(text, line+"\n") || strings.Contains(text, "\n"+line+"\n")
+}
+
+// jsonTranscript is a transcript in a JSON file.
+type jsonTranscript struct {
+	File       string `json:"file"`
+	Model      string `json:"model"`
+	Transcript string `json:"transcript"`
+}
+
+// parseJSONTranscripts reads the transcripts of a JSON document: an object
+// with a transcript, a list of them, or an object with a sources list.
+func parseJSONTranscripts(content []byte) ([]source, error) {
+	var list []jsonTranscript
+	content = bytes.TrimSpace(content)
+	switch {
+	case len(content) > 0 && content[0] == '[':
+		if err := json.Unmarshal(content, &list); err != nil {
+			return nil, err
+		}
+	default:
+		var doc struct {
+			jsonTranscript
+			Sources []jsonTranscript `json:"sources"`
+		}
+		if err := json.Unmarshal(content, &doc); err != nil {
+			return nil, err
+		}
+		list = doc.Sources
+		if doc.Transcript != "" {
+			list = append([]jsonTranscript{doc.jsonTranscript}, list...)
+		}
+	}
+
+	var sources []source
+	for _, t := range list {
+		if transcript := strings.TrimSpace(t.Transcript); transcript != "" {
+			sources = append(sources, source{Path: t.File, Model: t.Model, Transcript: transcript})
+		}
+	}
+	return sources, nil
+}
+
+// transcriptSections collects the transcripts of the sections of a file.
+type transcriptSections struct {
+	sources []source
+	current *source
+	b       strings.Builder
+}
+
+// start ends the current section and starts the transcript of path.
+func (s *transcriptSections) start(path string) {
+	s.end()
+	s.current = &source{Path: path}
+}
+
+// write adds a line to the current section, if any.
+func (s *transcriptSections) write(line string) {
+	if s.current != nil {
+		s.b.WriteString(line)
+		s.b.WriteByte('\n')
+	}
+}
+
+// end ends the current section, if any. Empty transcripts are dropped.
+func (s *transcriptSections) end() {
+	if s.current != nil {
+		s.current.Transcript = strings.TrimSpace(s.b.String())
+		if s.current.Transcript != "" {
+			s.sources = append(s.sources, *s.current)
+		}
+	}
+	s.current = nil
+	s.b.Reset()
+}
+
+// parseProgressTranscripts reads the transcripts of a progress file. The
+// partial summaries and the synthesis that follow them are ignored.
+func parseProgressTranscripts(text string) []source {
+	var sections transcriptSections
+
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	scanner.Buffer(nil, len(text)+1)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, progressHeader) && strings.HasSuffix(line, ":"):
+			sections.start(strings.TrimSuffix(strings.TrimPrefix(line, progressHeader), ":"))
+		case line == "Synthesis:" || strings.HasPrefix(line, "Partial summary "):
+			sections.end()
+		default:
+			sections.write(line)
+		}
+	}
+	sections.end()
+	return sections.sources
+}
+
+// reportFrontMatter is the part of the front matter of a report describing
+// the sources.
+type reportFrontMatter struct {
+	Sources []struct {
+		File     string `yaml:"file"`
+		Model    string `yaml:"model"`
+		Duration string `yaml:"duration"`
+	} `yaml:"sources"`
+}
+
+// parseReportTranscripts reads the "### file" sections under the
+// "## Transcripts" headings of a report; an appended report has several of
+// them. The headings are the labels of the sources, see labelSources. The
+// file, model and duration of the sources are restored from the front matter
+// when it lists them.
+func parseReportTranscripts(text string) ([]source, error) {
+	var front reportFrontMatter
+	if rest, ok := strings.CutPrefix(text, "---\n"); ok {
+		if matter, _, ok := strings.Cut(rest, "\n---\n"); ok {
+			if err := yaml.Unmarshal([]byte(matter), &front); err != nil {
+				return nil, fmt.Errorf("invalid front matter: %w", err)
+			}
+		}
+	}
+
+	var sections transcriptSections
+
+	inTranscripts := false
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	scanner.Buffer(nil, len(text)+1)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "## Transcripts":
+			sections.end()
+			inTranscripts = true
+		case strings.HasPrefix(line, "# ") || strings.HasPrefix(line, "## "):
+			sections.end()
+			inTranscripts = false
+		case inTranscripts && strings.HasPrefix(line, "### "):
+			sections.start(strings.TrimPrefix(line, "### "))
+		default:
+			sections.write(line)
+		}
+	}
+	sections.end()
+	sources := sections.sources
+
+	// Match the sections with the sources of the front matter, in order.
+	used := make([]bool, len(front.Sources))
+	for i := range sources {
+		for j, s := range front.Sources {
+			if used[j] || !isLabelOf(sources[i].Path, s.File) {
+				continue
+			}
+			used[j] = true
+			sources[i].Path, sources[i].Model = s.File, s.Model
+			if d, err := time.ParseDuration(s.Duration); err == nil {
+				sources[i].Duration = d
+			}
+			break
+		}
+	}
+	return sources, nil
+}
+
+// labelSources sets the label of every source: the base name of its path,
+// with as many parent directories as needed to tell it apart from the
+// sources with the same base name, and its position among the sources if
+// their paths are the same. The model is given the labels to attribute its
+// quotes, so they must be unique.
+func labelSources(sources []source) {
+	depths := make([]int, len(sources))
+	for i := range depths {
+		depths[i] = 1
+	}
+	for {
+		for i := range sources {
+			sources[i].Label = pathSuffix(sources[i].Path, depths[i])
+		}
+		deeper := false
+		for _, group := range sameLabels(sources) {
+			for _, i := range group {
+				if pathSuffix(sources[i].Path, depths[i]+1) != sources[i].Label {
+					depths[i]++
+					deeper = true
+				}
+			}
+		}
+		if !deeper {
+			break
+		}
+	}
+	for _, group := range sameLabels(sources) {
+		for _, i := range group {
+			sources[i].Label = fmt.Sprintf("%s #%d", sources[i].Label, i+1)
+		}
+	}
+}
+
+// sameLabels returns the groups of sources with the same label.
+func sameLabels(sources []source) [][]int {
+	byLabel := make(map[string][]int, len(sources))
+	var labels []string
+	for i, src := range sources {
+		if byLabel[src.Label] == nil {
+			labels = append(labels, src.Label)
+		}
+		byLabel[src.Label] = append(byLabel[src.Label], i)
+	}
+	var groups [][]int
+	for _, label := range labels {
+		if group := byLabel[label]; len(group) > 1 {
+			groups = append(groups, group)
+		}
+	}
+	return groups
+}
+
+// pathSuffix returns the last n elements of path, separated by slashes.
+func pathSuffix(path string, n int) string {
+	elements := strings.FieldsFunc(filepath.ToSlash(filepath.Clean(path)), func(r rune) bool { return r == '/' })
+	if n > len(elements) {
+		n = len(elements)
+	}
+	return strings.Join(elements[len(elements)-n:], "/")
+}
+
+// label returns the label of the source, or the base name of its path if
+// the sources were not labelled.
+func (s source) label() string {
+	if s.Label != "" {
+		return s.Label
+	}
+	return filepath.Base(s.Path)
+}
+
+// is reports whether file, as given by the model, names the source, see
+// isLabelOf.
+func (s source) is(file string) bool {
+	return isLabelOf(s.label(), file)
+}
+
+// isLabelOf reports whether label names file: file is the label or a path
+// ending with it, leaving out the position labelSources adds to the labels
+// of the same paths.
+func isLabelOf(label, file string) bool {
+	file = filepath.ToSlash(strings.TrimSpace(file))
+	if file == label || strings.HasSuffix(file, "/"+label) {
+		return true
+	}
+	if i := strings.LastIndex(label, " #"); i > 0 {
+		if _, err := strconv.Atoi(label[i+2:]); err == nil {
+			return isLabelOf(label[:i], file)
+		}
+	}
+	return false
+}
+
+// findSource returns the source named file by the model.
+func findSource(sources []source, file string) (source, bool) {
+	for _, src := range sources {
+		if src.is(file) {
+			return src, true
+		}
+	}
+	return source{}, false
+}
+
+// formatCorpus wraps every transcript in a <transcript> tag naming its file.
+func formatCorpus(sources []source) string {
+	labelled := make([]string, len(sources))
+	for i, src := range sources {
+		labelled[i] = labelTranscript(src)
+	}
+	return strings.Join(labelled, "\n\n")
+}
+
+// labelTranscript wraps the transcript of src in a <transcript> tag naming
+// its file by its label.
+func labelTranscript(src source) string {
+	return fmt.Sprintf("<transcript file=%q>\n%s\n</transcript>", src.label(), src.Transcript)
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeTranscripts(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("failed to write transcripts: %v", err)
+	}
+	return path
+}
+
+// TestLoadTranscriptsReport tests that the transcripts of a report are read back with their sources
+func TestLoadTranscriptsReport(t *testing.T) {
+	tmpl, err := loadReportTemplate("")
+	if err != nil {
+		t.Fatalf("failed to load default template: %v", err)
+	}
+	rep := testReport()
+	rep.Sources[1].Model = "gemini-2.5-flash"
+	var buf bytes.Buffer
+	if err := rep.render(&buf, tmpl); err != nil {
+		t.Fatalf("render failed: %v", err)
+	}
+	// An appended run adds a second transcripts section without front matter.
+	appended := report{Title: "interview", Appended: true, Sources: []source{{Path: "/tmp/chunk_002.m4a", Transcript: "Speaker A: bye"}}, Synthesis: "other"}
+	if err := appended.render(&buf, tmpl); err != nil {
+		t.Fatalf("render failed: %v", err)
+	}
+
+	sources, err := loadTranscripts(writeTranscripts(t, "report.md", buf.String()))
+	if err != nil {
+		t.Fatalf("loadTranscripts failed: %v", err)
+	}
+	if len(sources) != 3 {
+		t.Fatalf("Expected 3 transcripts, got %+v", sources)
+	}
+	if sources[0].Path != "/tmp/chunk_000.m4a" || sources[0].Duration != 25*time.Minute || sources[0].Transcript != "Speaker A: hello" {
+		t.Errorf("Unexpected first source: %+v", sources[0])
+	}
+	if sources[1].Model != "gemini-2.5-flash" || sources[1].Transcript != "Speaker B: \"quoted\" answer" {
+		t.Errorf("Unexpected second source: %+v", sources[1])
+	}
+	if sources[2].Path != "chunk_002.m4a" || sources[2].Transcript != "Speaker A: bye" {
+		t.Errorf("Unexpected appended source: %+v", sources[2])
+	}
+}
+
+// TestLoadTranscriptsReportLabels tests that the transcripts with the same file name are told apart in a report
+func TestLoadTranscriptsReportLabels(t *testing.T) {
+	rep := testReport()
+	rep.Sources = []source{
+		{Path: "out/a/chunk_000.m4a", Transcript: "Speaker A: first", Duration: time.Minute},
+		{Path: "out/b/chunk_000.m4a", Transcript: "Speaker A: second", Duration: 2 * time.Minute},
+	}
+	labelSources(rep.Sources)
+	content := testRender(t, rep)
+	if !strings.Contains(content, "### a/chunk_000.m4a\n") || !strings.Contains(content, "### b/chunk_000.m4a\n") {
+		t.Fatalf("Expected the transcripts to be headed by their label, got:\n%s", content)
+	}
+
+	sources, err := loadTranscripts(writeTranscripts(t, "report.md", content))
+	if err != nil {
+		t.Fatalf("loadTranscripts failed: %v", err)
+	}
+	if len(sources) != 2 || sources[0].Path != "out/a/chunk_000.m4a" || sources[1].Path != "out/b/chunk_000.m4a" || sources[1].Duration != 2*time.Minute {
+		t.Errorf("Unexpected sources: %+v", sources)
+	}
+}
+
+// TestLoadTranscriptsFormats tests the progress, JSON and plain text files
+func TestLoadTranscriptsFormats(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		paths   []string
+		texts   []string
+	}{
+		{
+			name:    "run.md.progress",
+			content: "Generated transcript for a.m4a:\nhello\n\nGenerated transcript for b.m4a:\nworld\n\n\n\nSynthesis:\nignored\n",
+			paths:   []string{"a.m4a", "b.m4a"},
+			texts:   []string{"hello", "world"},
+		},
+		{
+			name:    "entry.json",
+			content: `{"file": "a.m4a", "model": "m", "transcript": "hello"}`,
+			paths:   []string{"a.m4a"},
+			texts:   []string{"hello"},
+		},
+		{
+			name:    "report.json",
+			content: `{"title": "t", "sources": [{"file": "a.m4a", "transcript": "hello"}, {"file": "b.m4a", "transcript": "world"}]}`,
+			paths:   []string{"a.m4a", "b.m4a"},
+			texts:   []string{"hello", "world"},
+		},
+		{
+			name:    "transcript.txt",
+			content: "[00:00] Speaker A: hello\n",
+			texts:   []string{"[00:00] Speaker A: hello"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeTranscripts(t, tt.name, tt.content)
+			sources, err := loadTranscripts(path)
+			if err != nil {
+				t.Fatalf("loadTranscripts failed: %v", err)
+			}
+			if len(sources) != len(tt.texts) {
+				t.Fatalf("Expected %d transcripts, got %+v", len(tt.texts), sources)
+			}
+			for i, src := range sources {
+				expected := path
+				if tt.paths != nil {
+					expected = tt.paths[i]
+				}
+				if src.Path != expected || src.Transcript != tt.texts[i] {
+					t.Errorf("Unexpected source %d: %+v", i, src)
+				}
+			}
+		})
+	}
+
+	if _, err := loadTranscripts(writeTranscripts(t, "empty.json", "[]")); err == nil {
+		t.Error("Expected an error for a file without transcript")
+	}
+}
+
+// TestLabelSources tests that the sources with the same file name get distinct labels
+func TestLabelSources(t *testing.T) {
+	sources := []source{
+		{Path: "out/a/chunk_000.m4a"},
+		{Path: "out/b/chunk_000.m4a"},
+		{Path: "x/a/chunk_001.m4a"},
+		{Path: "y/a/chunk_001.m4a"},
+		{Path: "interview.m4a"},
+		{Path: "interview.m4a"},
+		{Path: "/tmp/notes.md"},
+	}
+	labelSources(sources)
+	expected := []string{"a/chunk_000.m4a", "b/chunk_000.m4a", "x/a/chunk_001.m4a", "y/a/chunk_001.m4a", "interview.m4a #5", "interview.m4a #6", "notes.md"}
+	for i, src := range sources {
+		if src.Label != expected[i] {
+			t.Errorf("Expected label %q for %s, got %q", expected[i], src.Path, src.Label)
+		}
+	}
+	if corpus := formatCorpus(sources[:2]); !strings.Contains(corpus, `<transcript file="b/chunk_000.m4a">`) {
+		t.Errorf("Expected the transcripts to be tagged with their label, got:\n%s", corpus)
+	}
+	if !sources[1].is("b/chunk_000.m4a") || !sources[1].is("/data/out/b/chunk_000.m4a") || sources[1].is("chunk_000.m4a") {
+		t.Error("Expected a source to be named by its label only")
+	}
+}